@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+// venerableAnnotations are the annotations of the plugin that are not copied
+// to the new app.
+var venerableAnnotations = []string{lockAnnotation, venerableAnnotation, retainedAtAnnotation, expiresAtAnnotation}
+
+type AppFeature struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// AppMetadata holds the labels and annotations of an app. A nil value
+// removes the key when sent to the Cloud Controller.
+type AppMetadata struct {
+	Labels      map[string]*string `json:"labels,omitempty"`
+	Annotations map[string]*string `json:"annotations,omitempty"`
+}
+
+func (repo *ApplicationRepo) GetAppFeatures(appGUID string) ([]AppFeature, error) {
+	var resp struct {
+		Resources []AppFeature `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/apps/%s/features", appGUID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Resources, nil
+}
+
+func (repo *ApplicationRepo) SetAppFeature(appGUID, name string, enabled bool) error {
+	return repo.curl(nil, "PATCH", fmt.Sprintf("/v3/apps/%s/features/%s", appGUID, name), map[string]bool{"enabled": enabled})
+}
+
+func (repo *ApplicationRepo) GetAppMetadata(appGUID string) (AppMetadata, error) {
+	var resp struct {
+		Metadata AppMetadata `json:"metadata"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/apps/%s", appGUID), nil)
+	return resp.Metadata, err
+}
+
+func (repo *ApplicationRepo) UpdateAppMetadata(appGUID string, metadata AppMetadata) error {
+	return repo.curl(nil, "PATCH", fmt.Sprintf("/v3/apps/%s", appGUID), map[string]AppMetadata{"metadata": metadata})
+}
+
+// copyAppSettings applies to the new app the settings of the venerable app
+// that are not part of the exported manifest: app features (ssh,
+// revisions, ...) and metadata. Settings that cannot be read or applied are
+// reported but do not fail the operation.
+func copyAppSettings(appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copying app features and metadata from %s to new %s\n",
+		terminal.EntityNameColor(venerableName),
+		terminal.EntityNameColor(appName),
+	)
+	var failures []string
+
+	features, err := appRepo.GetAppFeatures(oldAppGUID)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("app features: %s", err))
+	}
+	for _, feature := range features {
+		if err := appRepo.SetAppFeature(newAppGUID, feature.Name, feature.Enabled); err != nil {
+			failures = append(failures, fmt.Sprintf("feature %s (enabled: %t): %s", feature.Name, feature.Enabled, err))
+		}
+	}
+
+	metadata, err := appRepo.GetAppMetadata(oldAppGUID)
+	// these annotations of the plugin describe the venerable app itself, the
+	// history of the app is carried over
+	for _, key := range venerableAnnotations {
+		delete(metadata.Annotations, key)
+	}
+	if err == nil && (len(metadata.Labels) > 0 || len(metadata.Annotations) > 0) {
+		err = appRepo.UpdateAppMetadata(newAppGUID, metadata)
+	}
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("labels and annotations: %s", err))
+	}
+
+	if len(failures) == 0 {
+		fmt.Println("OK")
+		return nil
+	}
+	fmt.Println(terminal.WarningColor("The following settings could not be preserved:"))
+	for _, failure := range failures {
+		fmt.Println(terminal.WarningColor("  " + failure))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// App is an app as listed by the Cloud Controller, with the name of its
+// space.
+type App struct {
+	GUID      string    `json:"guid"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Lifecycle struct {
+		Type string `json:"type"`
+		Data struct {
+			Buildpacks []string `json:"buildpacks"`
+			Stack      string   `json:"stack"`
+		} `json:"data"`
+	} `json:"lifecycle"`
+	Metadata struct {
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Relationships struct {
+		Space struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"space"`
+	} `json:"relationships"`
+
+	SpaceName string `json:"-"`
+	OrgName   string `json:"-"`
+}
+
+// appScope selects the apps of a list of orgs or spaces, by GUID.
+type appScope struct {
+	OrgGUIDs   []string
+	SpaceGUIDs []string
+}
+
+// currentScope returns the current space, or the current org when org is
+// set.
+func (repo *ApplicationRepo) currentScope(org bool) (appScope, error) {
+	if org {
+		o, err := repo.conn.GetCurrentOrg()
+		if err != nil {
+			return appScope{}, err
+		}
+		if o.Guid == "" {
+			return appScope{}, fmt.Errorf("no org targeted")
+		}
+		return appScope{OrgGUIDs: []string{o.Guid}}, nil
+	}
+	s, err := repo.conn.GetCurrentSpace()
+	if err != nil {
+		return appScope{}, err
+	}
+	if s.Guid == "" {
+		return appScope{}, fmt.Errorf("no space targeted")
+	}
+	return appScope{SpaceGUIDs: []string{s.Guid}}, nil
+}
+
+// orgScope returns the orgs of the given names.
+func (repo *ApplicationRepo) orgScope(names []string) (appScope, error) {
+	query := url.Values{}
+	query.Set("names", strings.Join(names, ","))
+	query.Set("per_page", "5000")
+	var resp struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+			Name string `json:"name"`
+		} `json:"resources"`
+	}
+	if err := repo.curl(&resp, "GET", "/v3/organizations?"+query.Encode(), nil); err != nil {
+		return appScope{}, err
+	}
+	scope := appScope{}
+	for _, name := range names {
+		found := false
+		for _, org := range resp.Resources {
+			if org.Name == name {
+				scope.OrgGUIDs = append(scope.OrgGUIDs, org.GUID)
+				found = true
+			}
+		}
+		if !found {
+			return appScope{}, fmt.Errorf("org '%s' not found", name)
+		}
+	}
+	return scope, nil
+}
+
+// selectScope returns the scope selected by the --org, --orgs, --all and
+// --spaces flags: the current space by default.
+func (repo *ApplicationRepo) selectScope(org bool, orgs string, all bool, spaces string) (appScope, error) {
+	switch {
+	case all:
+		return appScope{}, nil
+	case orgs != "":
+		return repo.orgScope(strings.Split(orgs, ","))
+	case org || spaces != "":
+		return repo.currentScope(true)
+	}
+	return repo.currentScope(false)
+}
+
+// inSpaces tells whether the app is in one of the comma separated spaces,
+// or if there are none.
+func inSpaces(app App, spaces string) bool {
+	return spaces == "" || slices.Contains(strings.Split(spaces, ","), app.SpaceName)
+}
+
+// ListApps returns the apps of the scope, sorted by org, space and name.
+func (repo *ApplicationRepo) ListApps(scope appScope) ([]App, error) {
+	query := url.Values{}
+	query.Set("include", "space.organization")
+	query.Set("per_page", "5000")
+	if len(scope.SpaceGUIDs) > 0 {
+		query.Set("space_guids", strings.Join(scope.SpaceGUIDs, ","))
+	}
+	if len(scope.OrgGUIDs) > 0 {
+		query.Set("organization_guids", strings.Join(scope.OrgGUIDs, ","))
+	}
+
+	var apps []App
+	spaces := map[string][2]string{} // space guid -> org guid, space name
+	orgs := map[string]string{}      // org guid -> org name
+	path := "/v3/apps?" + query.Encode()
+	for path != "" {
+		var resp struct {
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
+			Resources []App `json:"resources"`
+			Included  struct {
+				Spaces []struct {
+					GUID          string `json:"guid"`
+					Name          string `json:"name"`
+					Relationships struct {
+						Organization struct {
+							Data struct {
+								GUID string `json:"guid"`
+							} `json:"data"`
+						} `json:"organization"`
+					} `json:"relationships"`
+				} `json:"spaces"`
+				Organizations []struct {
+					GUID string `json:"guid"`
+					Name string `json:"name"`
+				} `json:"organizations"`
+			} `json:"included"`
+		}
+		if err := repo.curl(&resp, "GET", path, nil); err != nil {
+			return nil, err
+		}
+		apps = append(apps, resp.Resources...)
+		for _, space := range resp.Included.Spaces {
+			spaces[space.GUID] = [2]string{space.Relationships.Organization.Data.GUID, space.Name}
+		}
+		for _, org := range resp.Included.Organizations {
+			orgs[org.GUID] = org.Name
+		}
+
+		path = ""
+		if resp.Pagination.Next != nil {
+			next, err := url.Parse(resp.Pagination.Next.Href)
+			if err != nil {
+				return nil, err
+			}
+			path = next.RequestURI()
+		}
+	}
+
+	for i := range apps {
+		space := spaces[apps[i].Relationships.Space.Data.GUID]
+		apps[i].OrgName = orgs[space[0]]
+		apps[i].SpaceName = space[1]
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].OrgName != apps[j].OrgName {
+			return apps[i].OrgName < apps[j].OrgName
+		}
+		if apps[i].SpaceName != apps[j].SpaceName {
+			return apps[i].SpaceName < apps[j].SpaceName
+		}
+		return apps[i].Name < apps[j].Name
+	})
+	return apps, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+type Package struct {
+	GUID  string `json:"guid"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+	// Data describes the image of Docker packages
+	Data struct {
+		Image    string `json:"image"`
+		Username string `json:"username"`
+	} `json:"data"`
+}
+
+type Build struct {
+	GUID    string `json:"guid"`
+	State   string `json:"state"`
+	Error   string `json:"error"`
+	Droplet *struct {
+		GUID string `json:"guid"`
+	} `json:"droplet"`
+}
+
+func (repo *ApplicationRepo) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	var app plugin_models.GetAppModel
+	_, err := repo.call("cf app "+appName, func() ([]string, error) {
+		var err error
+		app, err = repo.conn.GetApp(appName)
+		return nil, err
+	})
+	return app, err
+}
+
+// GetCurrentPackage returns the most recent package of the app that can be
+// staged.
+func (repo *ApplicationRepo) GetCurrentPackage(appGUID string) (Package, error) {
+	var resp struct {
+		Resources []Package `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/apps/%s/packages?states=READY&order_by=-created_at&per_page=1", appGUID), nil)
+	if err != nil {
+		return Package{}, err
+	}
+	if len(resp.Resources) == 0 {
+		return Package{}, fmt.Errorf("app has no package ready to be staged")
+	}
+	return resp.Resources[0], nil
+}
+
+// CreateBuild stages the package, with the buildpacks of the app unless
+// others are given.
+func (repo *ApplicationRepo) CreateBuild(packageGUID string, buildpacks []string) (Build, error) {
+	var build Build
+	body := map[string]interface{}{"package": map[string]string{"guid": packageGUID}}
+	if len(buildpacks) > 0 {
+		body["lifecycle"] = map[string]interface{}{
+			"type": "buildpack",
+			"data": map[string]interface{}{"buildpacks": buildpacks},
+		}
+	}
+	err := repo.curl(&build, "POST", "/v3/builds", body)
+	return build, err
+}
+
+func (repo *ApplicationRepo) GetBuild(buildGUID string) (Build, error) {
+	var build Build
+	err := repo.curl(&build, "GET", fmt.Sprintf("/v3/builds/%s", buildGUID), nil)
+	return build, err
+}
+
+func (repo *ApplicationRepo) SetCurrentDroplet(appGUID, dropletGUID string) error {
+	body := map[string]interface{}{"data": map[string]string{"guid": dropletGUID}}
+	return repo.curl(nil, "PATCH", fmt.Sprintf("/v3/apps/%s/relationships/current_droplet", appGUID), body)
+}
+
+// CopyDroplet copies the droplet to the app.
+func (repo *ApplicationRepo) CopyDroplet(dropletGUID, appGUID string) (Droplet, error) {
+	var droplet Droplet
+	body := map[string]interface{}{"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]string{"guid": appGUID}}}}
+	err := repo.curl(&droplet, "POST", "/v3/droplets?source_guid="+url.QueryEscape(dropletGUID), body)
+	return droplet, err
+}
+
+func (repo *ApplicationRepo) GetDroplet(dropletGUID string) (Droplet, error) {
+	var droplet Droplet
+	err := repo.curl(&droplet, "GET", fmt.Sprintf("/v3/droplets/%s", dropletGUID), nil)
+	return droplet, err
+}
+
+// restageInPlace stages a new droplet from the current package of a
+// stopped app and makes it the current droplet, leaving the app stopped.
+// When buildpacks are given, the app is staged with them and keeps them.
+func restageInPlace(ctx context.Context, appRepo *ApplicationRepo, appName string, buildpacks []string) error {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	pkg, err := appRepo.GetCurrentPackage(appGUID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Staging stopped app %s in place\n", terminal.EntityNameColor(appName))
+	var build Build
+	err = withLogs(appRepo, appName, func() error {
+		build, err = stageBuild(ctx, appRepo, pkg.GUID, buildpacks)
+		return err
+	})
+	if err != nil {
+		fmt.Println("FAILED")
+		return err
+	}
+
+	if err := appRepo.SetCurrentDroplet(appGUID, build.Droplet.GUID); err != nil {
+		fmt.Println("FAILED")
+		return err
+	}
+	if len(buildpacks) > 0 {
+		if err := appRepo.UpdateAppBuildpacks(appGUID, buildpacks); err != nil {
+			fmt.Println("FAILED")
+			return err
+		}
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// stageBuild stages a droplet from the package and waits for the staging to
+// complete.
+func stageBuild(ctx context.Context, appRepo *ApplicationRepo, packageGUID string, buildpacks []string) (Build, error) {
+	pb := NewIndeterminateProgressBar(os.Stdout, "")
+	build, err := appRepo.CreateBuild(packageGUID, buildpacks)
+	if err != nil {
+		return Build{}, err
+	}
+	for build.State == "STAGING" {
+		if err := sleep(ctx, 500*time.Millisecond); err != nil {
+			return Build{}, err
+		}
+		pb.Next()
+		build, err = appRepo.GetBuild(build.GUID)
+		if err != nil {
+			return Build{}, err
+		}
+	}
+	if build.State != "STAGED" || build.Droplet == nil {
+		return Build{}, fmt.Errorf("staging failed: %s", build.Error)
+	}
+	return build, nil
+}
+
+// copyDroplet makes the current droplet of the venerable app the current
+// droplet of the new app, so that starting it does not stage it again.
+func copyDroplet(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	current, err := appRepo.GetCurrentDroplet(oldAppGUID)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("%s has no current droplet", venerableName)
+	}
+
+	fmt.Printf("Copying droplet from %s to new %s\n",
+		terminal.EntityNameColor(venerableName),
+		terminal.EntityNameColor(appName),
+	)
+	droplet, err := appRepo.CopyDroplet(current.GUID, newAppGUID)
+	for err == nil && droplet.State == "COPYING" {
+		if err = sleep(ctx, 500*time.Millisecond); err == nil {
+			droplet, err = appRepo.GetDroplet(droplet.GUID)
+		}
+	}
+	if err == nil && droplet.State != "STAGED" {
+		err = fmt.Errorf("copy of droplet %s failed: %s", current.GUID, droplet.State)
+	}
+	if err == nil {
+		err = appRepo.SetCurrentDroplet(newAppGUID, droplet.GUID)
+	}
+	if err != nil {
+		fmt.Println("FAILED")
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Buildpack is a buildpack installed by an admin.
+type Buildpack struct {
+	GUID      string    `json:"guid"`
+	Name      string    `json:"name"`
+	Stack     string    `json:"stack"`
+	Filename  string    `json:"filename"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Droplet is a staged droplet of an app.
+type Droplet struct {
+	GUID       string    `json:"guid"`
+	State      string    `json:"state"`
+	Stack      string    `json:"stack"`
+	CreatedAt  time.Time `json:"created_at"`
+	Buildpacks []struct {
+		Name          string `json:"name"`
+		BuildpackName string `json:"buildpack_name"`
+		Version       string `json:"version"`
+	} `json:"buildpacks"`
+}
+
+func (repo *ApplicationRepo) GetBuildpacks() ([]Buildpack, error) {
+	var resp struct {
+		Resources []Buildpack `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", "/v3/buildpacks?per_page=5000", nil)
+	return resp.Resources, err
+}
+
+// GetCurrentDroplet returns the current droplet of the app, or nil if it
+// has none.
+func (repo *ApplicationRepo) GetCurrentDroplet(appGUID string) (*Droplet, error) {
+	var droplet Droplet
+	err := repo.curl(&droplet, "GET", fmt.Sprintf("/v3/apps/%s/droplets/current", appGUID), nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == 404 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &droplet, nil
+}
+
+// versionPattern matches the version in the filename of a buildpack.
+var versionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// findBuildpack returns the installed buildpack of that name for the stack,
+// or else the one of that name without any stack.
+func findBuildpack(buildpacks []Buildpack, name, stack string) *Buildpack {
+	var stackLess *Buildpack
+	for i := range buildpacks {
+		if buildpacks[i].Name != name {
+			continue
+		}
+		switch buildpacks[i].Stack {
+		case stack:
+			return &buildpacks[i]
+		case "":
+			if stackLess == nil {
+				stackLess = &buildpacks[i]
+			}
+		}
+	}
+	return stackLess
+}
+
+// stagedWithLatest tells whether the droplet was staged after the last
+// update of every buildpack it was staged with, and with the version found in
+// the filename of the buildpack, if any. Droplets staged with a buildpack
+// that is not installed, such as a git URL, are never considered up to date.
+func stagedWithLatest(droplet *Droplet, buildpacks []Buildpack) bool {
+	if droplet == nil || len(droplet.Buildpacks) == 0 {
+		return false
+	}
+	for _, used := range droplet.Buildpacks {
+		installed := findBuildpack(buildpacks, used.Name, droplet.Stack)
+		if installed == nil || installed.UpdatedAt.After(droplet.CreatedAt) {
+			return false
+		}
+		if used.Version != "" && versionPattern.MatchString(installed.Filename) &&
+			!strings.Contains(installed.Filename, strings.TrimPrefix(used.Version, "v")) {
+			return false
+		}
+	}
+	return true
+}
+
+// buildpackList is a repeatable flag of buildpack names or URLs.
+type buildpackList []string
+
+func (l *buildpackList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *buildpackList) Set(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("empty buildpack name")
+	}
+	*l = append(*l, name)
+	return nil
+}
+
+// withBuildpacks returns a manifest edit replacing the buildpacks of the
+// app with the given ones.
+func withBuildpacks(buildpacks []string) func(app ManifestApp) error {
+	return func(app ManifestApp) error {
+		delete(app, "buildpack")
+		names := make([]interface{}, 0, len(buildpacks))
+		for _, name := range buildpacks {
+			names = append(names, name)
+		}
+		app["buildpacks"] = names
+		return nil
+	}
+}
+
+// UpdateAppBuildpacks sets the buildpacks the app is staged with.
+func (repo *ApplicationRepo) UpdateAppBuildpacks(appGUID string, buildpacks []string) error {
+	body := map[string]interface{}{"lifecycle": map[string]interface{}{
+		"type": "buildpack",
+		"data": map[string]interface{}{"buildpacks": buildpacks},
+	}}
+	return repo.curl(nil, "PATCH", fmt.Sprintf("/v3/apps/%s", appGUID), body)
+}
+
+// onLatestBuildpacks tells whether the current droplet of the app is staged
+// with the latest buildpacks.
+func onLatestBuildpacks(appRepo *ApplicationRepo, appName string, buildpacks []Buildpack) (bool, error) {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return false, err
+	}
+	droplet, err := appRepo.GetCurrentDroplet(appGUID)
+	if err != nil {
+		return false, err
+	}
+	return stagedWithLatest(droplet, buildpacks), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func day(n int) time.Time {
+	return time.Date(2026, 1, n, 0, 0, 0, 0, time.UTC)
+}
+
+func stagedDroplet(stack string, createdAt time.Time, buildpacks ...string) *Droplet {
+	droplet := &Droplet{Stack: stack, CreatedAt: createdAt}
+	for i := 0; i+1 < len(buildpacks); i += 2 {
+		droplet.Buildpacks = append(droplet.Buildpacks, struct {
+			Name          string `json:"name"`
+			BuildpackName string `json:"buildpack_name"`
+			Version       string `json:"version"`
+		}{Name: buildpacks[i], Version: buildpacks[i+1]})
+	}
+	return droplet
+}
+
+func TestFindBuildpack(t *testing.T) {
+	buildpacks := []Buildpack{
+		{GUID: "any-java", Name: "java_buildpack"},
+		{GUID: "fs3-java", Name: "java_buildpack", Stack: "cflinuxfs3"},
+		{GUID: "fs4-java", Name: "java_buildpack", Stack: "cflinuxfs4"},
+		{GUID: "fs4-go", Name: "go_buildpack", Stack: "cflinuxfs4"},
+	}
+	tests := []struct {
+		name, stack string
+		want        string
+	}{
+		{"java_buildpack", "cflinuxfs4", "fs4-java"},
+		{"java_buildpack", "cflinuxfs3", "fs3-java"},
+		{"java_buildpack", "windows", "any-java"},
+		{"go_buildpack", "cflinuxfs4", "fs4-go"},
+		{"go_buildpack", "cflinuxfs3", ""},
+		{"https://github.com/cloudfoundry/java-buildpack.git", "cflinuxfs4", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if found := findBuildpack(buildpacks, test.name, test.stack); found != nil {
+			got = found.GUID
+		}
+		if got != test.want {
+			t.Errorf("findBuildpack(%q, %q): got %q, want %q", test.name, test.stack, got, test.want)
+		}
+	}
+}
+
+func TestStagedWithLatest(t *testing.T) {
+	buildpacks := []Buildpack{
+		{Name: "java_buildpack", Stack: "cflinuxfs4", Filename: "java-buildpack-cflinuxfs4-v4.62.0.zip", UpdatedAt: day(10)},
+		{Name: "java_buildpack", Filename: "java-buildpack-v4.50.0.zip", UpdatedAt: day(1)},
+		{Name: "custom_buildpack", Filename: "custom_buildpack.zip", UpdatedAt: day(10)},
+		{Name: "nodejs_buildpack", Stack: "cflinuxfs4", Filename: "nodejs-buildpack-cflinuxfs4-v1.8.20.zip", UpdatedAt: day(10)},
+	}
+	tests := []struct {
+		name    string
+		droplet *Droplet
+		want    bool
+	}{
+		{"no droplet", nil, false},
+		{"no buildpack", stagedDroplet("cflinuxfs4", day(20)), false},
+		{"staged after the update", stagedDroplet("cflinuxfs4", day(20), "java_buildpack", "v4.62.0"), true},
+		{"buildpack updated after the droplet", stagedDroplet("cflinuxfs4", day(5), "java_buildpack", "v4.62.0"), false},
+		{"other version in the filename", stagedDroplet("cflinuxfs4", day(20), "java_buildpack", "v4.61.1"), false},
+		{"no version reported", stagedDroplet("cflinuxfs4", day(20), "java_buildpack", ""), true},
+		{"version missing from the filename", stagedDroplet("cflinuxfs4", day(20), "custom_buildpack", "2.1.0"), true},
+		{"version missing from the filename, updated after the droplet", stagedDroplet("cflinuxfs4", day(5), "custom_buildpack", "2.1.0"), false},
+		{"buildpack not installed", stagedDroplet("cflinuxfs4", day(20), "https://github.com/cloudfoundry/java-buildpack.git", "4.62.0"), false},
+		{"one of the buildpacks not installed", stagedDroplet("cflinuxfs4", day(20), "java_buildpack", "4.62.0", "python_buildpack", "1.8.0"), false},
+		{"buildpack of another stack only", stagedDroplet("cflinuxfs3", day(20), "nodejs_buildpack", "1.8.20"), false},
+		{"stack-specific buildpack preferred", stagedDroplet("cflinuxfs4", day(20), "java_buildpack", "4.50.0"), false},
+		{"stack-less buildpack for another stack", stagedDroplet("cflinuxfs3", day(5), "java_buildpack", "4.50.0"), true},
+		{"stack-less buildpack of another version", stagedDroplet("cflinuxfs3", day(5), "java_buildpack", "4.62.0"), false},
+	}
+	for _, test := range tests {
+		if got := stagedWithLatest(test.droplet, buildpacks); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
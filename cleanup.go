@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"code.cloudfoundry.org/cli/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// retainedAtAnnotation records when the venerable copy of an app was
+	// retained by --no-delete or --no-stop
+	retainedAtAnnotation = annotationPrefix + "retained-at"
+	// expiresAtAnnotation records when the retained venerable copy of an app
+	// can be deleted by bg-cleanup
+	expiresAtAnnotation = annotationPrefix + "expires-at"
+
+	defaultRetention = 24 * time.Hour
+	// deleteTimeout bounds the wait for the deletion of an app
+	deleteTimeout = 5 * time.Minute
+)
+
+// markRetained records on the venerable copy of an app that it was
+// retained, and until when. A failure is reported but does not fail the
+// operation.
+func markRetained(appRepo *ApplicationRepo, venerableName string, retainFor time.Duration) {
+	now := time.Now().UTC().Truncate(time.Second)
+	retainedAt := now.Format(time.RFC3339)
+	expiresAt := now.Add(retainFor).Format(time.RFC3339)
+	appGUID, err := appRepo.GetAppGuid(venerableName)
+	if err == nil {
+		err = appRepo.UpdateAppMetadata(appGUID, AppMetadata{Annotations: map[string]*string{
+			retainedAtAnnotation: &retainedAt,
+			expiresAtAnnotation:  &expiresAt,
+		}})
+	}
+	if err != nil {
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not record the expiry of %s: %s", venerableName, err)))
+	}
+}
+
+// retention returns when the venerable copy was retained, and when it
+// expires. Copies retained by a version of the plugin that did not record it
+// are considered retained at their last update, and never expire.
+func retention(app App) (retainedAt, expiresAt time.Time) {
+	retainedAt = app.UpdatedAt
+	if t, err := time.Parse(time.RFC3339, app.Metadata.Annotations[retainedAtAnnotation]); err == nil {
+		retainedAt = t
+	}
+	if t, err := time.Parse(time.RFC3339, app.Metadata.Annotations[expiresAtAnnotation]); err == nil {
+		expiresAt = t
+	}
+	return retainedAt, expiresAt
+}
+
+// DeleteApplicationByGUID deletes the app, in whatever space it is, and
+// waits for its deletion to complete.
+func (repo *ApplicationRepo) DeleteApplicationByGUID(ctx context.Context, appGUID string) error {
+	if err := repo.curl(nil, "DELETE", "/v3/apps/"+appGUID, nil); err != nil {
+		return err
+	}
+	for {
+		_, err := repo.GetAppMetadata(appGUID)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == 404 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func (repo *ApplicationRepo) DeleteRoute(routeGUID string) error {
+	return repo.curl(nil, "DELETE", "/v3/routes/"+routeGUID, nil)
+}
+
+// deleteVenerable deletes a venerable copy with its network policies, unless
+// skipPolicies is set, then the routes it leaves without any destination.
+func deleteVenerable(ctx context.Context, appRepo *ApplicationRepo, app App, skipPolicies bool) error {
+	routes, err := appRepo.GetAppRoutes(app.GUID)
+	if err != nil {
+		return err
+	}
+	if !skipPolicies {
+		policies, err := appRepo.GetNetworkPolicies(app.GUID)
+		if err != nil {
+			return err
+		}
+		if err := appRepo.DeleteNetworkPolicies(policies); err != nil {
+			return err
+		}
+	}
+	if err := appRepo.DeleteApplicationByGUID(ctx, app.GUID); err != nil {
+		return err
+	}
+	for _, route := range routes {
+		destinations, err := appRepo.GetRouteDestinations(route.GUID)
+		if err != nil {
+			return err
+		}
+		if len(destinations) > 0 {
+			continue
+		}
+		fmt.Printf("Deleting route %s, no longer mapped\n", terminal.EntityNameColor(route.URL))
+		if err := appRepo.DeleteRoute(route.GUID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanup deletes the expired venerable copies of the apps of the selected
+// orgs and spaces.
+func (BgRestagePlugin) cleanup(cliConnection plugin.CliConnection, args []string) error {
+	fs := flag.NewFlagSet("cf bg-cleanup", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "Delete the old copies retained for longer than this, instead of the expired ones")
+	org := fs.Bool("org", false, "Clean up the apps of the whole current org instead of the current space")
+	orgs := fs.String("orgs", "", "Comma separated names of the orgs to clean up, instead of the current space")
+	all := fs.Bool("all", false, "Clean up the apps of all the orgs")
+	spaces := fs.String("spaces", "", "Comma separated names of the spaces to clean up, within the selected orgs")
+	dryRun := fs.Bool("dry-run", false, "List the old copies that would be deleted, without deleting them")
+	force := fs.Bool("f", false, "Force deletion without confirmation")
+	skipNetworkPolicies := fs.Bool("skip-network-policies", false, "Do not delete the network policies of the old copies, e.g. without the network.write scope")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+	if *olderThan < 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal --older-than")
+	}
+
+	appRepo, err := NewApplicationRepo(cliConnection)
+	if err != nil {
+		return err
+	}
+	defer appRepo.DeleteDir()
+
+	scope, err := appRepo.selectScope(*org, *orgs, *all, *spaces)
+	if err != nil {
+		return err
+	}
+	apps, err := appRepo.ListApps(scope)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	table := terminal.NewTable([]string{"org", "space", "name", "old copy of", "retained since", "expires"})
+	var expired []App
+	for _, app := range apps {
+		venerableOf, ok := app.Metadata.Annotations[venerableAnnotation]
+		if !ok || !inSpaces(app, *spaces) {
+			continue
+		}
+		retainedAt, expiresAt := retention(app)
+		if *olderThan > 0 {
+			if now.Sub(retainedAt) < *olderThan {
+				continue
+			}
+		} else if expiresAt.IsZero() || now.Before(expiresAt) {
+			continue
+		}
+		// an operation in progress may need it, e.g. bg-rollback
+		if value, ok := app.Metadata.Annotations[lockAnnotation]; ok {
+			var info lockInfo
+			if json.Unmarshal([]byte(value), &info) == nil && !info.Expired() {
+				fmt.Printf("%s: skipped, locked by %s\n", app.Name, info)
+				continue
+			}
+		}
+		expires := "never"
+		if !expiresAt.IsZero() {
+			expires = expiresAt.Local().Format(time.RFC1123)
+		}
+		table.Add(app.OrgName, app.SpaceName, app.Name, venerableOf, retainedAt.Local().Format(time.RFC1123), expires)
+		expired = append(expired, app)
+	}
+
+	if len(expired) == 0 {
+		fmt.Println("No old copy to delete")
+		return nil
+	}
+	if err := table.PrintTo(os.Stdout); err != nil {
+		return err
+	}
+	if *dryRun {
+		return nil
+	}
+	if !*force && !confirm(fmt.Sprintf("\nReally delete these %d applications and their routes no longer mapped?", len(expired))) {
+		fmt.Println("Delete cancelled")
+		return nil
+	}
+
+	failed := 0
+	for _, app := range expired {
+		fmt.Printf("Deleting app %s in org %s / space %s\n",
+			terminal.EntityNameColor(app.Name),
+			terminal.EntityNameColor(app.OrgName),
+			terminal.EntityNameColor(app.SpaceName),
+		)
+		err := withTimeout(context.Background(), deleteTimeout, "deleting", func(ctx context.Context) error {
+			return deleteVenerable(ctx, appRepo, app, *skipNetworkPolicies)
+		})
+		if err != nil {
+			fmt.Println("FAILED")
+			fmt.Printf("error: %s: %s\n", app.Name, err)
+			failed++
+			continue
+		}
+		fmt.Println("OK")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d old copies could not be deleted", failed, len(expired))
+	}
+	return nil
+}
+
+// confirm asks the user a yes or no question on the terminal.
+func confirm(question string) bool {
+	fmt.Print(question + "> ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
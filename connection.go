@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+// serialConnection serializes the calls to the cf CLI. They are RPCs over a
+// single connection whose replies, and the output of the commands, share one
+// buffer: concurrent calls, from parallel workers or the log tail, would
+// mix up their results.
+type serialConnection struct {
+	mu   sync.Mutex
+	conn plugin.CliConnection
+}
+
+func newSerialConnection(conn plugin.CliConnection) plugin.CliConnection {
+	if _, ok := conn.(*serialConnection); ok {
+		return conn
+	}
+	return &serialConnection{conn: conn}
+}
+
+func (c *serialConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.CliCommandWithoutTerminalOutput(args...)
+}
+
+func (c *serialConnection) CliCommand(args ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.CliCommand(args...)
+}
+
+func (c *serialConnection) GetCurrentOrg() (plugin_models.Organization, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetCurrentOrg()
+}
+
+func (c *serialConnection) GetCurrentSpace() (plugin_models.Space, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetCurrentSpace()
+}
+
+func (c *serialConnection) Username() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.Username()
+}
+
+func (c *serialConnection) UserGuid() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.UserGuid()
+}
+
+func (c *serialConnection) UserEmail() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.UserEmail()
+}
+
+func (c *serialConnection) IsLoggedIn() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.IsLoggedIn()
+}
+
+func (c *serialConnection) IsSSLDisabled() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.IsSSLDisabled()
+}
+
+func (c *serialConnection) HasOrganization() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.HasOrganization()
+}
+
+func (c *serialConnection) HasSpace() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.HasSpace()
+}
+
+func (c *serialConnection) ApiEndpoint() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.ApiEndpoint()
+}
+
+func (c *serialConnection) ApiVersion() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.ApiVersion()
+}
+
+func (c *serialConnection) HasAPIEndpoint() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.HasAPIEndpoint()
+}
+
+func (c *serialConnection) LoggregatorEndpoint() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.LoggregatorEndpoint()
+}
+
+func (c *serialConnection) DopplerEndpoint() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.DopplerEndpoint()
+}
+
+func (c *serialConnection) AccessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.AccessToken()
+}
+
+func (c *serialConnection) GetApp(appName string) (plugin_models.GetAppModel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetApp(appName)
+}
+
+func (c *serialConnection) GetApps() ([]plugin_models.GetAppsModel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetApps()
+}
+
+func (c *serialConnection) GetOrgs() ([]plugin_models.GetOrgs_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetOrgs()
+}
+
+func (c *serialConnection) GetSpaces() ([]plugin_models.GetSpaces_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetSpaces()
+}
+
+func (c *serialConnection) GetOrgUsers(orgName string, args ...string) ([]plugin_models.GetOrgUsers_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetOrgUsers(orgName, args...)
+}
+
+func (c *serialConnection) GetSpaceUsers(orgName, spaceName string) ([]plugin_models.GetSpaceUsers_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetSpaceUsers(orgName, spaceName)
+}
+
+func (c *serialConnection) GetServices() ([]plugin_models.GetServices_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetServices()
+}
+
+func (c *serialConnection) GetService(serviceInstance string) (plugin_models.GetService_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetService(serviceInstance)
+}
+
+func (c *serialConnection) GetOrg(orgName string) (plugin_models.GetOrg_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetOrg(orgName)
+}
+
+func (c *serialConnection) GetSpace(spaceName string) (plugin_models.GetSpace_Model, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.GetSpace(spaceName)
+}
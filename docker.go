@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+// dockerLifecycle is the lifecycle of the apps pushed with --docker-image.
+const dockerLifecycle = "docker"
+
+// dockerPasswordEnv is the environment variable giving the password of the
+// registry to the cf CLI.
+const dockerPasswordEnv = "CF_DOCKER_PASSWORD"
+
+// GetAppLifecycle returns the lifecycle type of the app: buildpack, cnb or
+// docker.
+func (repo *ApplicationRepo) GetAppLifecycle(appGUID string) (string, error) {
+	var app App
+	err := repo.curl(&app, "GET", fmt.Sprintf("/v3/apps/%s", appGUID), nil)
+	return app.Lifecycle.Type, err
+}
+
+// CopyPackage copies the package to the app. The Cloud Controller copies the
+// image and registry credentials of Docker packages.
+func (repo *ApplicationRepo) CopyPackage(packageGUID, appGUID string) (Package, error) {
+	var pkg Package
+	body := map[string]interface{}{"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]string{"guid": appGUID}}}}
+	err := repo.curl(&pkg, "POST", "/v3/packages?source_guid="+url.QueryEscape(packageGUID), body)
+	return pkg, err
+}
+
+func (repo *ApplicationRepo) CreateDockerPackage(appGUID, image, username, password string) (Package, error) {
+	var pkg Package
+	data := map[string]string{"image": image}
+	if username != "" {
+		data["username"] = username
+		data["password"] = password
+	}
+	body := map[string]interface{}{
+		"type":          "docker",
+		"data":          data,
+		"relationships": map[string]interface{}{"app": map[string]interface{}{"data": map[string]string{"guid": appGUID}}},
+	}
+	err := repo.curl(&pkg, "POST", "/v3/packages", body)
+	return pkg, err
+}
+
+// withoutDockerCredentials removes the registry username from the manifest,
+// so that pushing the new app does not need the password: the credentials
+// are copied with the package of the venerable app afterwards.
+func withoutDockerCredentials(app ManifestApp) error {
+	if docker, ok := app["docker"].(map[interface{}]interface{}); ok {
+		delete(docker, "username")
+	}
+	return nil
+}
+
+// dockerImageTag returns the image reference without its digest, if any,
+// e.g. registry.example.com/app:1.2 for
+// registry.example.com/app:1.2@sha256:0123...
+func dockerImageTag(image string) string {
+	name, _, _ := strings.Cut(image, "@")
+	return name
+}
+
+// copyDockerImage gives the new app the Docker image of the venerable app,
+// with its registry credentials. When refresh is set, the image is given by
+// its tag only, so that staging resolves the tag again.
+func copyDockerImage(appRepo *ApplicationRepo, appName, venerableName string, refresh bool) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	pkg, err := appRepo.GetCurrentPackage(oldAppGUID)
+	if err != nil {
+		return err
+	}
+
+	image := pkg.Data.Image
+	if refresh {
+		image = dockerImageTag(image)
+	}
+	fmt.Printf("Copying Docker image %s from %s to new %s\n",
+		terminal.EntityNameColor(image),
+		terminal.EntityNameColor(venerableName),
+		terminal.EntityNameColor(appName),
+	)
+	if refresh && image != pkg.Data.Image {
+		// the Cloud Controller never returns the password of the registry
+		password := os.Getenv(dockerPasswordEnv)
+		if pkg.Data.Username != "" && password == "" {
+			fmt.Println("FAILED")
+			return fmt.Errorf("the registry password of %s cannot be read back from the Cloud Controller: set %s to refresh its image", venerableName, dockerPasswordEnv)
+		}
+		_, err = appRepo.CreateDockerPackage(newAppGUID, image, pkg.Data.Username, password)
+	} else {
+		_, err = appRepo.CopyPackage(pkg.GUID, newAppGUID)
+	}
+	if err != nil {
+		fmt.Println("FAILED")
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type eventKind string
+
+const (
+	eventStarted   eventKind = "started"
+	eventSkipped   eventKind = "skipped"
+	eventSucceeded eventKind = "succeeded"
+	eventFailed    eventKind = "failed"
+	eventPending   eventKind = "pending"
+)
+
+// runEvent is something that happened to an app during a run.
+type runEvent struct {
+	Kind    eventKind
+	App     string
+	AppGUID string
+	Time    time.Time
+	// Message is why the app was skipped, or why the operation failed
+	Message string
+	// Rollback describes the rollback of a failed operation
+	Rollback string
+	// Venerable is the name of the venerable copy of the app, if any
+	Venerable string
+	// Droplet is the current droplet of the app when the operation started
+	// or completed, if known
+	Droplet *Droplet
+}
+
+// eventStream dispatches the events of a run to its listeners, one event at
+// a time.
+type eventStream struct {
+	mu        sync.Mutex
+	listeners []func(runEvent)
+}
+
+func (s *eventStream) Listen(listener func(runEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *eventStream) Emit(event runEvent) {
+	if s == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, listener := range s.listeners {
+		listener(event)
+	}
+}
+
+// consoleListener prints the outcome of the operation on each app.
+func consoleListener(action string, bulk bool) func(runEvent) {
+	return func(event runEvent) {
+		switch event.Kind {
+		case eventSkipped:
+			fmt.Printf("%s: %s\n", event.App, event.Message)
+		case eventSucceeded:
+			fmt.Print("\n" + action + " of " + event.App + " completed successfully\n\n")
+		case eventFailed:
+			if bulk {
+				fmt.Printf("error: %s: %s\n", event.App, event.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+// watchGate watches the new app for a while after it started, before the
+// venerable app is removed. It fails when a log line of the new app matches
+// one of the patterns, or when one of its instances crashes.
+type watchGate struct {
+	Duration time.Duration
+	Patterns regexpList
+}
+
+// regexpList is a repeatable flag of regular expressions.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	var patterns []string
+	for _, re := range *l {
+		patterns = append(patterns, re.String())
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (l *regexpList) Set(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+// CountCrashes returns the number of crashes of instances of the app since
+// the given time.
+func (repo *ApplicationRepo) CountCrashes(appGUID string, since time.Time) (int, error) {
+	query := url.Values{}
+	query.Set("types", "audit.app.process.crash")
+	query.Set("target_guids", appGUID)
+	query.Set("created_ats[gt]", since.UTC().Format(time.RFC3339))
+	query.Set("per_page", "1")
+	var resp struct {
+		Pagination struct {
+			TotalResults int `json:"total_results"`
+		} `json:"pagination"`
+	}
+	err := repo.curl(&resp, "GET", "/v3/audit_events?"+query.Encode(), nil)
+	return resp.Pagination.TotalResults, err
+}
+
+// watchApp watches the new app for the duration of the gate.
+func watchApp(ctx context.Context, appRepo *ApplicationRepo, appName string, gate watchGate) error {
+	if gate.Duration == 0 {
+		return nil
+	}
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	var logCacheURL string
+	if len(gate.Patterns) > 0 {
+		logCacheURL, err = appRepo.LogCacheURL()
+		if err != nil {
+			return errors.Wrap(err, "cannot watch logs")
+		}
+	}
+
+	fmt.Printf("Watching %s for %s\n", terminal.EntityNameColor(appName), gate.Duration)
+	start := time.Now()
+	deadline := start.Add(gate.Duration)
+	next := start
+	for {
+		if logCacheURL != "" {
+			lines, err := appRepo.ReadLogs(ctx, logCacheURL, appGUID, next)
+			if err != nil {
+				fmt.Println("FAILED")
+				return errors.Wrap(err, "reading logs")
+			}
+			for _, line := range lines {
+				fmt.Printf("%s %s\n", terminal.EntityNameColor(appName), line)
+				next = line.Timestamp.Add(time.Nanosecond)
+				for _, re := range gate.Patterns {
+					if re.MatchString(line.Text) {
+						fmt.Println("FAILED")
+						return fmt.Errorf("log line of %s matches '%s': %s", appName, re, line)
+					}
+				}
+			}
+		}
+
+		crashes, err := appRepo.CountCrashes(appGUID, start)
+		if err != nil {
+			fmt.Println("FAILED")
+			return err
+		}
+		if crashes > 0 {
+			fmt.Println("FAILED")
+			return fmt.Errorf("instances of %s crashed %d times while watched", appName, crashes)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			fmt.Println("OK")
+			return nil
+		}
+		if remaining > 2*time.Second {
+			remaining = 2 * time.Second
+		}
+		if err := sleep(ctx, remaining); err != nil {
+			fmt.Println("FAILED")
+			return errors.Wrapf(err, "watching %s", appName)
+		}
+	}
+}
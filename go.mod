@@ -8,6 +8,7 @@ require (
 	code.cloudfoundry.org/cli v7.1.0+incompatible
 	github.com/contraband/autopilot v0.0.0-20181203203448-1dc8b7d7d163
 	github.com/pkg/errors v0.9.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -57,5 +58,4 @@ require (
 	google.golang.org/grpc v1.47.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/cheggaaa/pb.v1 v1.0.28 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
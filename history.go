@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+// The history annotations tell when and how an app was last restaged or
+// restarted by the plugin. They are written on the app after a successful
+// operation.
+const (
+	historyByAnnotation               = annotationPrefix + "last-run-by"
+	historyPluginVersionAnnotation    = annotationPrefix + "plugin-version"
+	historyPreviousDropletAnnotation  = annotationPrefix + "previous-droplet"
+	historyBuildpacksBeforeAnnotation = annotationPrefix + "buildpacks-before"
+	historyBuildpacksAfterAnnotation  = annotationPrefix + "buildpacks-after"
+)
+
+// historyAtAnnotation records when the operation last succeeded on the app,
+// e.g. last-bg-restage.
+func historyAtAnnotation(operation string) string {
+	return annotationPrefix + "last-" + operation
+}
+
+// recordHistory records the successful operation on the app, whose
+// current droplet was before when it started and is after now.
+func recordHistory(appRepo *ApplicationRepo, appGUID, operation string, before, after *Droplet) {
+	operator, err := appRepo.Username()
+	if err != nil || operator == "" {
+		operator = "unknown"
+	}
+	version := pluginVersion()
+	annotations := map[string]string{
+		historyAtAnnotation(operation):    time.Now().UTC().Truncate(time.Second).Format(time.RFC3339),
+		historyByAnnotation:               operator,
+		historyPluginVersionAnnotation:    fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Build),
+		historyPreviousDropletAnnotation:  "",
+		historyBuildpacksBeforeAnnotation: "",
+		historyBuildpacksAfterAnnotation:  "",
+	}
+	if before != nil {
+		annotations[historyPreviousDropletAnnotation] = before.GUID
+		annotations[historyBuildpacksBeforeAnnotation] = dropletBuildpacks(before)
+	}
+	if after != nil {
+		annotations[historyBuildpacksAfterAnnotation] = dropletBuildpacks(after)
+	}
+
+	metadata := AppMetadata{Annotations: map[string]*string{}}
+	for key, value := range annotations {
+		value := value
+		if value == "" {
+			// do not leave the values of a previous operation
+			metadata.Annotations[key] = nil
+		} else {
+			metadata.Annotations[key] = &value
+		}
+	}
+	if err := appRepo.UpdateAppMetadata(appGUID, metadata); err != nil {
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not record the history of %s: %s", operation, err)))
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+// annotationPrefix prefixes the keys of the annotations written by the
+// plugin on apps.
+const annotationPrefix = "bg-restage.orange-cloudfoundry.github.io/"
+
+const (
+	lockAnnotation = annotationPrefix + "lock"
+	defaultLockTTL = 2 * time.Hour
+)
+
+// lockInfo describes who holds the lock of an app.
+type lockInfo struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Host      string    `json:"host"`
+	Operation string    `json:"operation"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (l lockInfo) Expired() bool {
+	return time.Now().After(l.ExpiresAt)
+}
+
+func (l lockInfo) String() string {
+	return fmt.Sprintf("%s by %s on %s since %s", l.Operation, l.Owner, l.Host, l.StartedAt.Local().Format(time.RFC1123))
+}
+
+// appLock is an advisory lock preventing concurrent operations on an app,
+// stored as an annotation on the app, and on its new copy once pushed.
+type appLock struct {
+	appRepo  *ApplicationRepo
+	info     lockInfo
+	appGUIDs []string
+}
+
+func (repo *ApplicationRepo) Username() (string, error) {
+	return repo.conn.Username()
+}
+
+// GetLock returns the lock held on the app, if any.
+func (repo *ApplicationRepo) GetLock(appGUID string) (*lockInfo, error) {
+	metadata, err := repo.GetAppMetadata(appGUID)
+	if err != nil {
+		return nil, err
+	}
+	value := metadata.Annotations[lockAnnotation]
+	if value == nil || *value == "" {
+		return nil, nil
+	}
+	var info lockInfo
+	if err := json.Unmarshal([]byte(*value), &info); err != nil {
+		return nil, fmt.Errorf("invalid lock annotation '%s'", *value)
+	}
+	return &info, nil
+}
+
+func (repo *ApplicationRepo) setLock(appGUID string, info *lockInfo) error {
+	var value *string
+	if info != nil {
+		data, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+		s := string(data)
+		value = &s
+	}
+	return repo.setAnnotation(appGUID, lockAnnotation, value)
+}
+
+// acquireLock locks the app for the operation. It fails when the app is
+// already locked by another operation, unless the lock has expired or
+// forceUnlock is set.
+func acquireLock(appRepo *ApplicationRepo, appName, operation string, ttl time.Duration, forceUnlock bool) (*appLock, error) {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return nil, err
+	}
+	held, err := appRepo.GetLock(appGUID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case held == nil:
+	case forceUnlock:
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Removing lock of %s: %s", appName, held)))
+	case held.Expired():
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Taking over expired lock of %s: %s", appName, held)))
+	default:
+		return nil, fmt.Errorf("%s is locked: %s. Use --force-unlock if that operation is no longer running", appName, held)
+	}
+
+	owner, err := appRepo.Username()
+	if err != nil || owner == "" {
+		owner = "unknown"
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	lock := &appLock{
+		appRepo: appRepo,
+		info: lockInfo{
+			ID:        hex.EncodeToString(id),
+			Owner:     owner,
+			Host:      host,
+			Operation: operation,
+			StartedAt: now,
+			ExpiresAt: now.Add(ttl),
+		},
+	}
+	if err := lock.Add(appName); err != nil {
+		return nil, err
+	}
+
+	// the Cloud Controller cannot compare and swap annotations: read the
+	// lock back to detect a concurrent acquisition
+	held, err = appRepo.GetLock(appGUID)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+	if held == nil || held.ID != lock.info.ID {
+		lock.appGUIDs = nil
+		return nil, fmt.Errorf("%s was locked concurrently by another operation", appName)
+	}
+	return lock, nil
+}
+
+// Add extends the lock to another app, such as the new copy of the locked
+// app.
+func (l *appLock) Add(appName string) error {
+	appGUID, err := l.appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	if err := l.appRepo.setLock(appGUID, &l.info); err != nil {
+		return err
+	}
+	l.appGUIDs = append(l.appGUIDs, appGUID)
+	return nil
+}
+
+// Release removes the lock from the apps still holding it.
+func (l *appLock) Release() {
+	for _, appGUID := range l.appGUIDs {
+		held, err := l.appRepo.GetLock(appGUID)
+		if err != nil || held == nil || held.ID != l.info.ID {
+			// deleted app, or lock taken over
+			continue
+		}
+		if err := l.appRepo.setLock(appGUID, nil); err != nil {
+			fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not release lock: %s", err)))
+		}
+	}
+	l.appGUIDs = nil
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+const (
+	// logTailLines is the number of log lines of the app included in the
+	// report of a failed staging or start
+	logTailLines = 20
+	// logPollInterval is the interval between two reads of log-cache
+	logPollInterval = time.Second
+)
+
+// logLine is a staging or application log line read from log-cache.
+type logLine struct {
+	Timestamp  time.Time
+	SourceType string
+	Instance   string
+	Stream     string
+	Text       string
+}
+
+func (l logLine) String() string {
+	return fmt.Sprintf("[%s/%s] %s %s", l.SourceType, l.Instance, l.Stream, l.Text)
+}
+
+// LogCacheURL returns the log-cache endpoint advertised by the Cloud
+// Controller.
+func (repo *ApplicationRepo) LogCacheURL() (string, error) {
+	var root struct {
+		Links struct {
+			LogCache *struct {
+				Href string `json:"href"`
+			} `json:"log_cache"`
+		} `json:"links"`
+	}
+	if err := repo.curl(&root, "GET", "/", nil); err != nil {
+		return "", err
+	}
+	if root.Links.LogCache == nil || root.Links.LogCache.Href == "" {
+		return "", fmt.Errorf("the Cloud Controller does not advertise a log-cache endpoint")
+	}
+	return root.Links.LogCache.Href, nil
+}
+
+// ReadLogs returns the staging and application logs of the app emitted
+// after start, oldest first.
+func (repo *ApplicationRepo) ReadLogs(ctx context.Context, logCacheURL, appGUID string, start time.Time) ([]logLine, error) {
+	token, err := repo.conn.AccessToken()
+	if err != nil {
+		return nil, err
+	}
+	sslDisabled, err := repo.conn.IsSSLDisabled()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("start_time", strconv.FormatInt(start.UnixNano(), 10))
+	query.Set("envelope_types", "LOG")
+	query.Set("limit", "1000")
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/read/%s?%s", strings.TrimSuffix(logCacheURL, "/"), appGUID, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: sslDisabled}
+	resp, err := (&http.Client{Transport: transport, Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("log-cache responded %s", resp.Status)
+	}
+
+	var result struct {
+		Envelopes struct {
+			Batch []struct {
+				Timestamp  string            `json:"timestamp"`
+				InstanceID string            `json:"instance_id"`
+				Tags       map[string]string `json:"tags"`
+				Log        *struct {
+					Payload string `json:"payload"`
+					Type    string `json:"type"`
+				} `json:"log"`
+			} `json:"batch"`
+		} `json:"envelopes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "decoding log-cache response")
+	}
+
+	var lines []logLine
+	for _, envelope := range result.Envelopes.Batch {
+		if envelope.Log == nil {
+			continue
+		}
+		sourceType := envelope.Tags["source_type"]
+		if sourceType != "STG" && !strings.HasPrefix(sourceType, "APP") {
+			continue
+		}
+		ns, err := strconv.ParseInt(envelope.Timestamp, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log timestamp '%s'", envelope.Timestamp)
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Log.Payload)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding log payload")
+		}
+		stream := "OUT"
+		if envelope.Log.Type == "ERR" {
+			stream = "ERR"
+		}
+		lines = append(lines, logLine{
+			Timestamp:  time.Unix(0, ns),
+			SourceType: sourceType,
+			Instance:   envelope.InstanceID,
+			Stream:     stream,
+			Text:       strings.TrimRight(string(payload), "\n"),
+		})
+	}
+	return lines, nil
+}
+
+// GetStagingFailure returns why the last staging of the app failed, if it
+// did.
+func (repo *ApplicationRepo) GetStagingFailure(appGUID string) (string, error) {
+	var app struct {
+		Entity struct {
+			StagingFailedReason      string `json:"staging_failed_reason"`
+			StagingFailedDescription string `json:"staging_failed_description"`
+		} `json:"entity"`
+	}
+	if err := repo.curl(&app, "GET", fmt.Sprintf("/v2/apps/%s", appGUID), nil); err != nil {
+		return "", err
+	}
+	if app.Entity.StagingFailedReason == "" {
+		return "", nil
+	}
+	if app.Entity.StagingFailedDescription == "" {
+		return app.Entity.StagingFailedReason, nil
+	}
+	return fmt.Sprintf("%s: %s", app.Entity.StagingFailedReason, app.Entity.StagingFailedDescription), nil
+}
+
+// logTail prints the logs of an app as they reach log-cache, prefixed with
+// the name of the app, and keeps the last ones.
+type logTail struct {
+	appRepo     *ApplicationRepo
+	appName     string
+	appGUID     string
+	logCacheURL string
+	next        time.Time
+
+	mu   sync.Mutex
+	last []logLine
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startLogTail(appRepo *ApplicationRepo, appName, appGUID string) (*logTail, error) {
+	logCacheURL, err := appRepo.LogCacheURL()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &logTail{
+		appRepo:     appRepo,
+		appName:     appName,
+		appGUID:     appGUID,
+		logCacheURL: logCacheURL,
+		next:        time.Now(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go t.run(ctx)
+	return t, nil
+}
+
+func (t *logTail) run(ctx context.Context) {
+	defer close(t.done)
+	warned := false
+	for {
+		err := t.poll(ctx)
+		if err != nil && ctx.Err() == nil && !warned {
+			fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not read logs of %s: %s", t.appName, err)))
+			warned = true
+		}
+		if sleep(ctx, logPollInterval) != nil {
+			return
+		}
+	}
+}
+
+func (t *logTail) poll(ctx context.Context) error {
+	lines, err := t.appRepo.ReadLogs(ctx, t.logCacheURL, t.appGUID, t.next)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range lines {
+		fmt.Printf("%s %s\n", terminal.EntityNameColor(t.appName), line)
+		t.last = append(t.last, line)
+		t.next = line.Timestamp.Add(time.Nanosecond)
+	}
+	if len(t.last) > logTailLines {
+		t.last = t.last[len(t.last)-logTailLines:]
+	}
+	return nil
+}
+
+// Stop stops tailing, after reading the logs that reached log-cache so far.
+func (t *logTail) Stop() {
+	t.cancel()
+	<-t.done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	t.poll(ctx)
+}
+
+// Report adds the last log lines of the app and the reason of a staging
+// failure to err.
+func (t *logTail) Report(err error) error {
+	var report string
+	if reason, rerr := t.appRepo.GetStagingFailure(t.appGUID); rerr == nil && reason != "" {
+		report += fmt.Sprintf("\nstaging failed: %s", reason)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.last) > 0 {
+		report += fmt.Sprintf("\nlast log lines of %s:", t.appName)
+		for _, line := range t.last {
+			report += "\n    " + line.String()
+		}
+	}
+	return fmt.Errorf("%w%s", err, report)
+}
+
+// withLogs runs f while streaming the staging and application logs of the
+// app, and reports the last of these logs when f fails. The logs are not
+// streamed when log-cache cannot be reached.
+func withLogs(appRepo *ApplicationRepo, appName string, f func() error) error {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	tail, err := startLogTail(appRepo, appName, appGUID)
+	if err != nil {
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Cannot stream logs of %s from log-cache: %s", appName, err)))
+		return f()
+	}
+	err = f()
+	tail.Stop()
+	if err != nil {
+		return tail.Report(err)
+	}
+	return nil
+}
+
+// startWithLogs starts the app through the Cloud Controller API, staging
+// its latest package first when it has no droplet, and prints its staging
+// and startup logs. Unlike cf start, it stops as soon as ctx is done, leaving
+// nothing running in the background; it does not wait for the instances to
+// run, see verifyProcesses.
+func startWithLogs(ctx context.Context, appRepo *ApplicationRepo, appName string) error {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	droplet, err := appRepo.GetCurrentDroplet(appGUID)
+	if err != nil {
+		return err
+	}
+	return withLogs(appRepo, appName, func() error {
+		if droplet == nil {
+			pkg, err := appRepo.GetCurrentPackage(appGUID)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Staging app %s\n", terminal.EntityNameColor(appName))
+			build, err := stageBuild(ctx, appRepo, pkg.GUID, nil)
+			if err == nil {
+				err = appRepo.SetCurrentDroplet(appGUID, build.Droplet.GUID)
+			}
+			if err != nil {
+				fmt.Println("FAILED")
+				return err
+			}
+			fmt.Println("OK")
+		}
+		fmt.Printf("Starting app %s\n", terminal.EntityNameColor(appName))
+		if err := appRepo.StartApplication(appGUID); err != nil {
+			fmt.Println("FAILED")
+			return err
+		}
+		fmt.Println("OK")
+		return nil
+	})
+}
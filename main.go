@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"code.cloudfoundry.org/cli/cf/terminal"
 	"code.cloudfoundry.org/cli/plugin"
 	"github.com/contraband/autopilot/rewind"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -23,106 +29,559 @@ func (p BgRestagePlugin) Run(cliConnection plugin.CliConnection, args []string)
 	// (defer doesn't work with os.Exit())
 	if err := p.run(cliConnection, args); err != nil {
 		fmt.Println("error:", err)
+		if errors.Is(err, errTerminated) {
+			os.Exit(exitTerminated)
+		}
 		os.Exit(1)
 	}
 }
 
-func (BgRestagePlugin) run(cliConnection plugin.CliConnection, args []string) error {
+func (p BgRestagePlugin) run(cliConnection plugin.CliConnection, args []string) error {
+	// the workers and the log tails call the cf CLI concurrently
+	cliConnection = newSerialConnection(cliConnection)
 	action := args[0]
 	if action == "CLI-MESSAGE-UNINSTALL" {
 		return nil
 	}
+	if action == "bg-status" {
+		return p.status(cliConnection, args[1:])
+	}
+	if action == "bg-report" {
+		return p.report(cliConnection, args[1:])
+	}
+	if action == "bg-rollback" {
+		return p.rollback(cliConnection, args[1:])
+	}
+	if action == "bg-cleanup" {
+		return p.cleanup(cliConnection, args[1:])
+	}
 
 	fs := flag.NewFlagSet("cf "+action, flag.ExitOnError)
 	nodelete := fs.Bool("no-delete", false, "Stop but do not delete the old copy of the application when "+action+" completes")
 	nostop := fs.Bool("no-stop", false, "Do not stop the old copy of the application when "+action+" completes (implies --no-delete)")
-	venerable := fs.String("venerable-suffix", "-venerable", "Suffix appended to the name of the old copy of the application")
+	retainFor := fs.Duration("retain-for", defaultRetention, "How long the old copy kept by --no-delete or --no-stop is retained, before bg-cleanup deletes it")
+	venerableName := fs.String("venerable-name", defaultVenerableName, "Template of the name of the old copy of the application, with the fields .Name and .Timestamp, e.g. {{.Name}}-old-{{.Timestamp}}")
+	venerableSuffix := fs.String("venerable-suffix", "", "Suffix appended as is to the name of the old copy of the application, instead of --venerable-name")
+	disambiguate := fs.Bool("disambiguate", false, "Append a number to the name of the old copy of the application when an application of that name already exists, instead of failing")
+	windowSpec := fs.String("window", "", "Only start applications between these times, e.g. 22:00-05:00")
+	windowTZ := fs.String("window-tz", "Local", "Timezone of --window, e.g. Europe/Paris")
+	windowDays := fs.String("window-days", "", "Comma separated weekdays on which --window opens, e.g. mon,tue,wed,thu (default every day)")
+	retries := fs.Int("retries", defaultRetryPolicy.MaxRetries, "Number of retries of a Cloud Controller call failing with a transient error")
+	retryDelay := fs.Duration("retry-delay", defaultRetryPolicy.BaseDelay, "Initial delay between retries, doubled on each retry")
+	retryMaxDelay := fs.Duration("retry-max-delay", defaultRetryPolicy.MaxDelay, "Maximum delay between retries")
+	parallel := fs.Int("parallel", 1, "Number of applications processed concurrently")
+	rateLimit := fs.Float64("rate-limit", 0, "Maximum number of Cloud Controller calls per second, for all applications (default unlimited)")
+	maxStagings := fs.Int("max-stagings", 0, "Maximum number of concurrent stagings (default unlimited)")
+	copyBitsTimeout := fs.Duration("copy-bits-timeout", defaultTimeouts.CopyBits, "Maximum duration of the copy of the application bits (0 for no limit)")
+	bindTimeout := fs.Duration("bind-timeout", defaultTimeouts.Bind, "Maximum duration of the binding of the service instances to the new application (0 for no limit)")
+	stagingTimeout := fs.Duration("staging-timeout", defaultTimeouts.Staging, "Maximum duration of the staging of the new application (0 for no limit)")
+	startTimeout := fs.Duration("start-timeout", defaultTimeouts.Start, "Maximum duration of the start of the new application (0 for no limit)")
+	verifyTimeout := fs.Duration("verify-timeout", defaultTimeouts.Verify, "Maximum wait for all the instances of the new application to be running (0 for no limit)")
+	timeout := fs.Duration("timeout", 0, "Maximum duration of the whole operation on each application, rolled back when exceeded (0 for no limit)")
+	lockTTL := fs.Duration("lock-ttl", defaultLockTTL, "Duration after which the lock taken on an application is considered stale")
+	forceUnlock := fs.Bool("force-unlock", false, "Remove the lock left on an application by an operation that is no longer running")
+	skipNetworkPolicies := fs.Bool("skip-network-policies", false, "Do not copy the network policies of the old copy of the application, e.g. without the network.write scope")
+	watch := fs.Duration("watch", 0, "Watch the new application for this long once started, and roll back if one of its instances crashes or if it logs a line matching --fail-on-log")
+	var failOnLog regexpList
+	fs.Var(&failOnLog, "fail-on-log", "Regular expression of the log lines of the new application that make it fail while watched, can be repeated")
+	metricsWindow := fs.Duration("compare-metrics", 0, "Compare the container metrics of the new and old applications for this long before removing the old one, and roll back on regression")
+	maxCPUIncrease := fs.Float64("max-cpu-increase", 50, "Maximum increase of the CPU usage of the new application, in percent (0 for no limit)")
+	maxMemoryIncrease := fs.Float64("max-memory-increase", 25, "Maximum increase of the memory usage of the new application, in percent (0 for no limit)")
+	maxDiskIncrease := fs.Float64("max-disk-increase", 25, "Maximum increase of the disk usage of the new application, in percent (0 for no limit)")
+	maxMemoryQuota := fs.Float64("max-memory-quota", 90, "Maximum memory usage of an instance of the new application, in percent of its quota (0 for no limit)")
+	force := new(bool)
+	refreshDockerImage := new(bool)
+	var buildpackOverride buildpackList
+	if action == "bg-restage" {
+		force = fs.Bool("force", false, "Restage the applications already staged with the latest version of their buildpacks")
+		fs.Var(&buildpackOverride, "buildpack", "Name or URL of the buildpack to stage the applications with instead of their own, can be repeated for multiple buildpacks")
+		refreshDockerImage = fs.Bool("refresh-docker-image", false, "Restage the Docker applications from the tag of their image, without the digest it may be pinned to, to pick up an updated image")
+	}
+	reportPath := fs.String("report", "", "Write a summary of the run to this file, as HTML if it ends with .html, as Markdown otherwise")
+	consoleURL := fs.String("console-url", "", "URL of the applications in a web console, linked from the --report summary, e.g. https://console.example.com/apps/{{.GUID}}")
 	fs.Parse(args[1:])
-	if fs.NArg() != 1 {
+	if fs.NArg() < 1 {
 		fs.Usage()
 		return fmt.Errorf("no application name specified")
 	}
 
-	appName := fs.Arg(0)
-	cleanup := deleteOnCleanup
+	appNames := fs.Args()
+	opts := options{
+		cleanup: deleteOnCleanup,
+		timeouts: timeouts{
+			CopyBits: *copyBitsTimeout,
+			Bind:     *bindTimeout,
+			Staging:  *stagingTimeout,
+			Start:    *startTimeout,
+			Verify:   *verifyTimeout,
+			Total:    *timeout,
+		},
+	}
+	opts.retainFor = *retainFor
+	opts.refreshDockerImage = *refreshDockerImage
+	opts.buildpackOverride = buildpackOverride
+	opts.lockTTL = *lockTTL
+	opts.forceUnlock = *forceUnlock
+	opts.skipNetworkPolicies = *skipNetworkPolicies
+	opts.watch = watchGate{Duration: *watch, Patterns: failOnLog}
+	opts.metrics = metricsGate{
+		Window:            *metricsWindow,
+		MaxCPUIncrease:    *maxCPUIncrease,
+		MaxMemoryIncrease: *maxMemoryIncrease,
+		MaxDiskIncrease:   *maxDiskIncrease,
+		MaxMemoryQuota:    *maxMemoryQuota,
+	}
 	if *nostop { // nostop takes precedence over nodelete (it's implicit that you can't delete without stopping)
-		cleanup = skipCleanup
+		opts.cleanup = skipCleanup
 	} else if *nodelete {
-		cleanup = stopOnCleanup
+		opts.cleanup = stopOnCleanup
+	}
+
+	if *venerableSuffix != "" {
+		if *venerableName != defaultVenerableName {
+			fs.Usage()
+			return fmt.Errorf("--venerable-suffix and --venerable-name are mutually exclusive")
+		}
+		*venerableName = suffixTemplate(*venerableSuffix)
+	}
+	namer, err := newVenerableNamer(*venerableName, *disambiguate)
+	if err != nil {
+		fs.Usage()
+		return fmt.Errorf("illegal --venerable-name: %s", err)
+	}
+	opts.venerable = namer
+
+	var window *maintenanceWindow
+	if *windowSpec != "" {
+		var err error
+		window, err = parseMaintenanceWindow(*windowSpec, *windowTZ, *windowDays)
+		if err != nil {
+			fs.Usage()
+			return err
+		}
+	}
+
+	if *retries < 0 || *retryDelay <= 0 || *retryMaxDelay < *retryDelay {
+		fs.Usage()
+		return fmt.Errorf("illegal retry settings")
+	}
+
+	if *lockTTL <= 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal --lock-ttl")
+	}
+
+	if *retainFor <= 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal --retain-for")
+	}
+
+	if *copyBitsTimeout < 0 || *bindTimeout < 0 || *stagingTimeout < 0 || *startTimeout < 0 || *verifyTimeout < 0 || *timeout < 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal timeout")
 	}
 
-	if *venerable == "" {
+	if *watch < 0 {
 		fs.Usage()
-		return fmt.Errorf("illegal --venerable-suffix")
+		return fmt.Errorf("illegal --watch")
+	}
+	if *watch == 0 && len(failOnLog) > 0 {
+		fs.Usage()
+		return fmt.Errorf("--fail-on-log needs --watch")
+	}
+
+	if *metricsWindow < 0 || *maxCPUIncrease < 0 || *maxMemoryIncrease < 0 || *maxDiskIncrease < 0 || *maxMemoryQuota < 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal metrics comparison settings")
+	}
+
+	if *parallel < 1 || *rateLimit < 0 || *maxStagings < 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal --parallel, --rate-limit or --max-stagings")
+	}
+
+	// the workers share the retry policy and the throttle, but each one
+	// needs its own directory for manifests and droplets
+	retry := retryPolicy{
+		MaxRetries: *retries,
+		BaseDelay:  *retryDelay,
+		MaxDelay:   *retryMaxDelay,
+	}
+	throttle := newThrottle(*rateLimit, *maxStagings)
+	var appRepos []*ApplicationRepo
+	for i := 0; i < min(*parallel, len(appNames)); i++ {
+		appRepo, err := NewApplicationRepo(cliConnection)
+		if err != nil {
+			return err
+		}
+		defer appRepo.DeleteDir()
+		appRepo.retry = retry
+		appRepo.throttle = throttle
+		appRepos = append(appRepos, appRepo)
+	}
+
+	opts.events = &eventStream{}
+	opts.events.Listen(consoleListener(action, len(appNames) > 1))
+	var summary *runSummary
+	if *reportPath != "" {
+		org, err := cliConnection.GetCurrentOrg()
+		if err != nil {
+			return err
+		}
+		space, err := cliConnection.GetCurrentSpace()
+		if err != nil {
+			return err
+		}
+		summary, err = newRunSummary(action, org.Name, space.Name, *consoleURL)
+		if err != nil {
+			return err
+		}
+		opts.events.Listen(summary.Listen)
+		defer func() {
+			if err := summary.Write(*reportPath); err != nil {
+				fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not write the run summary: %s", err)))
+			} else {
+				fmt.Printf("Run summary written to %s\n", *reportPath)
+			}
+		}()
+	}
+
+	// the buildpacks are listed once for all the apps; apps staged with
+	// other buildpacks are never up to date
+	if action == "bg-restage" && !*force && len(buildpackOverride) == 0 {
+		buildpacks, err := appRepos[0].GetBuildpacks()
+		if err != nil {
+			return err
+		}
+		opts.skipUpToDate = true
+		opts.buildpacks = buildpacks
+	}
+
+	var (
+		mu         sync.Mutex
+		next       int
+		errs       = make([]error, len(appNames))
+		inProgress = map[string]string{} // app name -> venerable name, once known
+	)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	opts.events.Listen(func(event runEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := inProgress[event.App]; ok && event.Kind == eventStarted {
+			inProgress[event.App] = event.Venerable
+		}
+	})
+	stopHandlingSignals := handleSignals(cancel, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		apps := map[string]string{}
+		for appName, venerableName := range inProgress {
+			apps[appName] = venerableName
+		}
+		return apps
+	}, func() {
+		for _, appRepo := range appRepos {
+			appRepo.DeleteDir()
+		}
+	})
+	defer stopHandlingSignals()
+
+	if window != nil && !window.Contains(time.Now()) {
+		opening := window.NextOpening(time.Now())
+		fmt.Printf("Waiting for maintenance window %s to open at %s\n", window, opening.Format(time.RFC1123))
+		if err := sleep(ctx, time.Until(opening)); err != nil {
+			return context.Cause(ctx)
+		}
+	}
+
+	// nextApp hands out the apps to the workers, until the run is
+	// interrupted or the maintenance window closes: apps already in progress
+	// are completed then, but no other one is started
+	nextApp := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if next == len(appNames) || ctx.Err() != nil || (window != nil && !window.Contains(time.Now())) {
+			return 0, false
+		}
+		inProgress[appNames[next]] = ""
+		next++
+		return next - 1, true
+	}
+	appDone := func(i int) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(inProgress, appNames[i])
+	}
+
+	var wg sync.WaitGroup
+	for _, appRepo := range appRepos {
+		wg.Add(1)
+		go func(appRepo *ApplicationRepo) {
+			defer wg.Done()
+			for {
+				i, ok := nextApp()
+				if !ok {
+					return
+				}
+				errs[i] = p.runApp(ctx, appRepo, action, appNames[i], opts)
+				appDone(i)
+			}
+		}(appRepo)
+	}
+	wg.Wait()
+
+	if len(appNames) == 1 && errs[0] != nil {
+		return errs[0]
+	}
+	var failed []string
+	for i, err := range errs[:next] {
+		if err != nil {
+			failed = append(failed, appNames[i])
+		}
+	}
+	pending := appNames[next:]
+	for _, appName := range pending {
+		opts.events.Emit(runEvent{Kind: eventPending, App: appName, Message: "not started"})
 	}
-	venerableSuffix = *venerable
 
-	appRepo, err := NewApplicationRepo(cliConnection)
+	if len(failed) == 0 && len(pending) == 0 {
+		_ = appRepos[0].ListApplications()
+		return nil
+	}
+	switch {
+	case len(pending) > 0 && ctx.Err() != nil:
+		fmt.Printf("\nTerminated, the following apps are pending:\n  %s\n", strings.Join(pending, " "))
+	case len(pending) > 0:
+		fmt.Printf("\nMaintenance window %s closed, the following apps are pending:\n  %s\n",
+			window, strings.Join(pending, " "))
+	}
+	if len(failed) > 0 {
+		fmt.Printf("\n%s failed for the following apps:\n  %s\n", action, strings.Join(failed, " "))
+	}
+	err = fmt.Errorf("%s completed for %d of %d apps", action, len(appNames)-len(failed)-len(pending), len(appNames))
+	if ctx.Err() != nil {
+		return errors.Wrap(context.Cause(ctx), err.Error())
+	}
+	return err
+}
+
+func (BgRestagePlugin) runApp(ctx context.Context, appRepo *ApplicationRepo, action, appName string, opts options) (err error) {
+	failed := runEvent{Kind: eventFailed, App: appName, Rollback: "nothing to roll back"}
+	defer func() {
+		if err != nil {
+			if failed.Message == "" {
+				failed.Message = err.Error()
+			}
+			opts.events.Emit(failed)
+		}
+	}()
+
+	if opts.timeouts.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.timeouts.Total,
+			fmt.Errorf("%s of %s did not complete within %s", action, appName, opts.timeouts.Total))
+		defer cancel()
+	}
+
+	app, err := appRepo.GetApp(appName)
 	if err != nil {
 		return err
 	}
-	defer appRepo.DeleteDir()
+	failed.AppGUID = app.Guid
+	stopped := app.State == "stopped"
+	lifecycle, err := appRepo.GetAppLifecycle(app.Guid)
+	if err != nil {
+		return err
+	}
+	docker := lifecycle == dockerLifecycle
+	if docker && len(opts.buildpackOverride) > 0 {
+		return fmt.Errorf("%s is a Docker application, it cannot be staged with --buildpack", appName)
+	}
+
+	if action == "bg-restart" && stopped {
+		opts.events.Emit(runEvent{Kind: eventSkipped, App: appName, AppGUID: app.Guid, Message: "stopped, nothing to restart"})
+		return nil
+	}
+
+	if opts.skipUpToDate {
+		upToDate, err := onLatestBuildpacks(appRepo, appName, opts.buildpacks)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			opts.events.Emit(runEvent{Kind: eventSkipped, App: appName, AppGUID: app.Guid,
+				Message: "already staged with the latest buildpacks, nothing to restage (use --force to restage it anyway)"})
+			return nil
+		}
+	}
+
+	// check the name of the venerable copy before any change
+	var venerableName string
+	if !(action == "bg-restage" && stopped) {
+		venerableName, err = opts.venerable.Name(appRepo, appName, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	lock, err := acquireLock(appRepo, appName, action, opts.lockTTL, opts.forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	// the droplets are only needed for the run summary and the history
+	before, _ := appRepo.GetCurrentDroplet(app.Guid)
+	opts.events.Emit(runEvent{Kind: eventStarted, App: appName, AppGUID: app.Guid, Venerable: venerableName, Droplet: before})
 
 	var actionList []rewind.Action
-	if action == "bg-restage" {
-		actionList = restageActions(appRepo, appName, cleanup)
-	} else /* action == "bg-restart" */ {
-		actionList = restartActions(appRepo, appName, cleanup)
+	switch {
+	case action == "bg-restage" && stopped:
+		// a stopped app must stay stopped: stage it in place instead
+		actionList = restageInPlaceActions(ctx, appRepo, appName, opts)
+	case action == "bg-restage":
+		actionList = restageActions(ctx, appRepo, appName, venerableName, docker, opts, lock)
+	default: /* action == "bg-restart" */
+		actionList = restartActions(ctx, appRepo, appName, venerableName, docker, opts, lock)
+	}
+	// do not start another step once the operation is cancelled, but let
+	// the rollback run, and keep track of both for the run summary
+	var forwardErr error
+	rollback := "nothing to roll back"
+	for i := range actionList {
+		forward := actionList[i].Forward
+		actionList[i].Forward = func() error {
+			if ctx.Err() != nil {
+				forwardErr = context.Cause(ctx)
+				return forwardErr
+			}
+			appRepo.ctx = ctx
+			defer func() { appRepo.ctx = nil }()
+			forwardErr = forward()
+			return forwardErr
+		}
+		if reverse := actionList[i].ReversePrevious; reverse != nil {
+			actionList[i].ReversePrevious = func() error {
+				rollback = "rolled back"
+				if err := reverse(); err != nil {
+					rollback = "rollback failed: " + err.Error()
+					return err
+				}
+				return nil
+			}
+		}
 	}
 	actions := rewind.Actions{
 		Actions:              actionList,
 		RewindFailureMessage: action + " failed: an attempt was made at rolling back changes. Please verify that everything is fine.",
 	}
 
-	if err := actions.Execute(); err != nil {
+	err = actions.Execute()
+	recordOutcome(appRepo, appName, action, err)
+	if err != nil {
+		failed.Message = forwardErr.Error()
+		failed.Rollback = rollback
 		return err
 	}
 
-	fmt.Print("\n" + action + " completed successfully\n\n")
-
-	_ = appRepo.ListApplications()
-
+	event := runEvent{Kind: eventSucceeded, App: appName}
+	if event.AppGUID, err = appRepo.GetAppGuid(appName); err == nil {
+		event.Droplet, _ = appRepo.GetCurrentDroplet(event.AppGUID)
+		recordHistory(appRepo, event.AppGUID, action, before, event.Droplet)
+	}
+	opts.events.Emit(event)
 	return nil
 }
 
-func (BgRestagePlugin) GetMetadata() plugin.PluginMetadata {
+func pluginVersion() plugin.VersionType {
 	major := 0
 	minor := 0
 	patch := 0
 	major, _ = strconv.Atoi(Major)
 	minor, _ = strconv.Atoi(Minor)
 	patch, _ = strconv.Atoi(Patch)
+	return plugin.VersionType{
+		Major: major,
+		Minor: minor,
+		Build: patch,
+	}
+}
 
+func (BgRestagePlugin) GetMetadata() plugin.PluginMetadata {
 	return plugin.PluginMetadata{
-		Name: "bg-restage",
-		Version: plugin.VersionType{
-			Major: major,
-			Minor: minor,
-			Build: patch,
-		},
+		Name:    "bg-restage",
+		Version: pluginVersion(),
 		Commands: []plugin.Command{
 			{
 				Name:     "bg-restage",
 				HelpText: "Perform a zero-downtime restage of an application",
 				UsageDetails: plugin.Usage{
-					Usage: "$ cf bg-restage application-to-restage",
+					Usage: "$ cf bg-restage [--no-delete | --no-stop] [--buildpack BUILDPACK]... [--window 22:00-05:00 [--window-tz TZ] [--window-days DAYS]] application-to-restage...",
 				},
 			},
 			{
 				Name:     "bg-restart",
 				HelpText: "Perform a zero-downtime restart of an application",
 				UsageDetails: plugin.Usage{
-					Usage: "$ cf bg-restart application-to-restage",
+					Usage: "$ cf bg-restart [--no-delete | --no-stop] [--window 22:00-05:00 [--window-tz TZ] [--window-days DAYS]] application-to-restart...",
+				},
+			},
+			{
+				Name:     "bg-rollback",
+				HelpText: "Perform a zero-downtime rollback of an application to the old copy retained by bg-restage or bg-restart",
+				UsageDetails: plugin.Usage{
+					Usage: "$ cf bg-rollback [--no-delete | --no-stop] [--venerable OLD-COPY] application-to-roll-back",
+				},
+			},
+			{
+				Name:     "bg-cleanup",
+				HelpText: "Delete the old copies of applications retained by bg-restage, bg-restart or bg-rollback once expired",
+				UsageDetails: plugin.Usage{
+					Usage: "$ cf bg-cleanup [--older-than DURATION] [--org | --orgs ORGS | --all] [--spaces SPACES] [--dry-run] [-f]",
+				},
+			},
+			{
+				Name:     "bg-status",
+				HelpText: "Show the applications locked by an operation, the leftover old copies and the outcome of the last operations",
+				UsageDetails: plugin.Usage{
+					Usage: "$ cf bg-status [--org]",
+				},
+			},
+			{
+				Name:     "bg-report",
+				HelpText: "Report on the stack, buildpacks and droplet age of applications, and whether they can be restaged",
+				UsageDetails: plugin.Usage{
+					Usage: "$ cf bg-report [--org | --orgs ORGS | --all] [--spaces SPACES] [--format table|csv|json]",
 				},
 			},
 		},
 	}
 }
 
-var venerableSuffix string // FIXME: this should not be a global variable
-
-func venerableAppName(appName string) string {
-	return fmt.Sprintf("%s%s", appName, venerableSuffix)
+// options tune the actions of an operation on an app.
+type options struct {
+	cleanup cleanupAction
+	// retainFor is how long the venerable copy is retained when it is not
+	// deleted
+	retainFor   time.Duration
+	timeouts    timeouts
+	lockTTL     time.Duration
+	forceUnlock bool
+	// skipNetworkPolicies leaves the network policies alone
+	skipNetworkPolicies bool
+	watch               watchGate
+	metrics             metricsGate
+	// skipUpToDate skips the apps already staged with the latest version
+	// of the buildpacks
+	skipUpToDate bool
+	buildpacks   []Buildpack
+	// buildpackOverride replaces the buildpacks of the apps, if set
+	buildpackOverride []string
+	// refreshDockerImage restages Docker apps from the tag of their image
+	refreshDockerImage bool
+	events             *eventStream
+	venerable          *venerableNamer
 }
 
 type cleanupAction int
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ManifestApp is the description of the application in the exported
+// manifest, as decoded by yaml.v2.
+type ManifestApp map[interface{}]interface{}
+
+// EditManifest loads the manifest exported by CreateManifest, hands the
+// application it describes to each edit in turn and writes the result back.
+func (repo *ApplicationRepo) EditManifest(edits ...func(app ManifestApp) error) error {
+	data, err := os.ReadFile(repo.manifestFilePath())
+	if err != nil {
+		return errors.Wrap(err, "reading manifest")
+	}
+	var manifest map[string]interface{}
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return errors.Wrap(err, "parsing manifest")
+	}
+	apps, _ := manifest["applications"].([]interface{})
+	if len(apps) != 1 {
+		return fmt.Errorf("expected exactly one application in manifest, found %d", len(apps))
+	}
+	app, ok := apps[0].(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected application entry in manifest")
+	}
+	for _, edit := range edits {
+		if err := edit(ManifestApp(app)); err != nil {
+			return err
+		}
+	}
+	data, err = yaml.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "encoding manifest")
+	}
+	return errors.Wrap(os.WriteFile(repo.manifestFilePath(), data, 0600), "writing manifest")
+}
+
+// exportManifest exports the manifest of the app and prepares it for the
+// push of the new app: services, routes and registry credentials are left
+// out as they are copied afterwards, and processes are given their live
+// scale. The other edits are applied last.
+func exportManifest(appRepo *ApplicationRepo, appName string, edits ...func(app ManifestApp) error) error {
+	if err := appRepo.CreateManifest(appName); err != nil {
+		return err
+	}
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	processes, err := appRepo.GetProcesses(appGUID)
+	if err != nil {
+		return err
+	}
+	edits = append([]func(app ManifestApp) error{withoutServices, withoutRoutes, withoutDockerCredentials, withLiveScale(processes)}, edits...)
+	return appRepo.EditManifest(edits...)
+}
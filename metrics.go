@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/formatters"
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+// metricsSampleInterval is the interval between two samples of the
+// container metrics of the apps
+const metricsSampleInterval = 5 * time.Second
+
+// metricsGate compares the container metrics of the new app to the ones of
+// the venerable app over a window, before the venerable app is removed. It
+// fails when the new app uses more than the allowed percentage increase of
+// CPU, memory or disk, or more than the allowed percentage of its memory
+// quota. A zero threshold is not checked.
+type metricsGate struct {
+	Window            time.Duration
+	MaxCPUIncrease    float64
+	MaxMemoryIncrease float64
+	MaxDiskIncrease   float64
+	MaxMemoryQuota    float64
+}
+
+// processMetrics accumulates the usage of the running instances of a
+// process.
+type processMetrics struct {
+	samples  int
+	cpu      float64
+	mem      float64
+	disk     float64
+	maxQuota float64 // highest memory usage seen, as a percentage of quota
+}
+
+func (m *processMetrics) add(stats []ProcessInstanceStats) {
+	for _, instance := range stats {
+		if instance.State != "RUNNING" {
+			continue
+		}
+		m.samples++
+		m.cpu += instance.Usage.CPU
+		m.mem += float64(instance.Usage.Mem)
+		m.disk += float64(instance.Usage.Disk)
+		if instance.MemQuota > 0 {
+			quota := 100 * float64(instance.Usage.Mem) / float64(instance.MemQuota)
+			if quota > m.maxQuota {
+				m.maxQuota = quota
+			}
+		}
+	}
+}
+
+// average returns the average CPU (as a percentage of a core), memory and
+// disk usage of an instance.
+func (m *processMetrics) average() (cpu, mem, disk float64) {
+	if m.samples == 0 {
+		return 0, 0, 0
+	}
+	n := float64(m.samples)
+	return 100 * m.cpu / n, m.mem / n, m.disk / n
+}
+
+// increase returns how much higher value is than reference, in percent.
+func increase(reference, value float64) float64 {
+	if reference == 0 {
+		return 0
+	}
+	return 100 * (value - reference) / reference
+}
+
+// compareMetrics samples the container metrics of the processes of both
+// apps for the window of the gate, and checks the new app against the
+// thresholds.
+func compareMetrics(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string, gate metricsGate) error {
+	if gate.Window == 0 {
+		return nil
+	}
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	oldProcesses, err := appRepo.GetProcesses(oldAppGUID)
+	if err != nil {
+		return err
+	}
+	newProcesses, err := appRepo.GetProcesses(newAppGUID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Comparing container metrics of %s and new %s for %s\n",
+		terminal.EntityNameColor(venerableName),
+		terminal.EntityNameColor(appName),
+		gate.Window,
+	)
+	oldMetrics := map[string]*processMetrics{}
+	newMetrics := map[string]*processMetrics{}
+	deadline := time.Now().Add(gate.Window)
+	for {
+		for _, sample := range []struct {
+			processes []Process
+			metrics   map[string]*processMetrics
+		}{{oldProcesses, oldMetrics}, {newProcesses, newMetrics}} {
+			for _, process := range sample.processes {
+				stats, err := appRepo.GetProcessStats(process.GUID)
+				if err != nil {
+					fmt.Println("FAILED")
+					return err
+				}
+				if sample.metrics[process.Type] == nil {
+					sample.metrics[process.Type] = &processMetrics{}
+				}
+				sample.metrics[process.Type].add(stats)
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		if remaining > metricsSampleInterval {
+			remaining = metricsSampleInterval
+		}
+		if err := sleep(ctx, remaining); err != nil {
+			fmt.Println("FAILED")
+			return errors.Wrapf(err, "comparing container metrics of %s", appName)
+		}
+	}
+
+	var regressions []string
+	for _, process := range newProcesses {
+		oldProcess, ok := oldMetrics[process.Type]
+		if !ok {
+			continue
+		}
+		newProcess := newMetrics[process.Type]
+		oldCPU, oldMem, oldDisk := oldProcess.average()
+		newCPU, newMem, newDisk := newProcess.average()
+		fmt.Printf("   %s: cpu %.1f%% -> %.1f%%, memory %s -> %s (up to %.0f%% of quota), disk %s -> %s\n",
+			process.Type,
+			oldCPU, newCPU,
+			formatters.ByteSize(int64(oldMem)), formatters.ByteSize(int64(newMem)), newProcess.maxQuota,
+			formatters.ByteSize(int64(oldDisk)), formatters.ByteSize(int64(newDisk)),
+		)
+
+		for _, check := range []struct {
+			name                    string
+			reference, value, limit float64
+		}{
+			{"cpu", oldCPU, newCPU, gate.MaxCPUIncrease},
+			{"memory", oldMem, newMem, gate.MaxMemoryIncrease},
+			{"disk", oldDisk, newDisk, gate.MaxDiskIncrease},
+		} {
+			if check.limit > 0 && increase(check.reference, check.value) > check.limit {
+				regressions = append(regressions, fmt.Sprintf("%s usage of process '%s' increased by %.0f%% (max %.0f%%)",
+					check.name, process.Type, increase(check.reference, check.value), check.limit))
+			}
+		}
+		if gate.MaxMemoryQuota > 0 && newProcess.maxQuota > gate.MaxMemoryQuota {
+			regressions = append(regressions, fmt.Sprintf("memory usage of process '%s' reached %.0f%% of its quota (max %.0f%%)",
+				process.Type, newProcess.maxQuota, gate.MaxMemoryQuota))
+		}
+	}
+	if len(regressions) > 0 {
+		fmt.Println("FAILED")
+		return fmt.Errorf("new %s regressed: %s", appName, strings.Join(regressions, ", "))
+	}
+	fmt.Println("OK")
+	return nil
+}
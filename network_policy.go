@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+const networkPoliciesPath = "/networking/v1/external/policies"
+
+type NetworkPolicy struct {
+	Source struct {
+		ID string `json:"id"`
+	} `json:"source"`
+	Destination struct {
+		ID       string `json:"id"`
+		Protocol string `json:"protocol"`
+		Ports    struct {
+			Start int `json:"start"`
+			End   int `json:"end"`
+		} `json:"ports"`
+	} `json:"destination"`
+}
+
+// GetNetworkPolicies returns the container-to-container network policies
+// having the app as source or destination. Reading them needs the
+// network.write or network.admin scope, which space developers do not have
+// by default.
+func (repo *ApplicationRepo) GetNetworkPolicies(appGUID string) ([]NetworkPolicy, error) {
+	var resp struct {
+		Policies []NetworkPolicy `json:"policies"`
+	}
+	err := repo.curl(&resp, "GET", networkPoliciesPath+"?id="+url.QueryEscape(appGUID), nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.Status == 401 || apiErr.Status == 403) {
+		return nil, fmt.Errorf("reading the network policies of the app needs the network.write or network.admin scope (status %d): "+
+			"ask for it, or use --skip-network-policies to leave the network policies alone", apiErr.Status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Policies, nil
+}
+
+func (repo *ApplicationRepo) CreateNetworkPolicies(policies []NetworkPolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	return repo.curl(nil, "POST", networkPoliciesPath, map[string][]NetworkPolicy{"policies": policies})
+}
+
+func (repo *ApplicationRepo) DeleteNetworkPolicies(policies []NetworkPolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	return repo.curl(nil, "POST", networkPoliciesPath+"/delete", map[string][]NetworkPolicy{"policies": policies})
+}
+
+// networkPolicyMigration carries the network policies of the venerable app
+// over to the new app. Policies are recreated before the new app starts so
+// that it can reach its backends (and be reached) from its first instance,
+// and the policies of the venerable app are only removed when it is deleted.
+// Nothing is done when skip is set.
+type networkPolicyMigration struct {
+	appRepo       *ApplicationRepo
+	appName       string
+	venerableName string
+	skip          bool
+	old           []NetworkPolicy
+	created       []NetworkPolicy
+}
+
+func (m *networkPolicyMigration) Copy() error {
+	if m.skip {
+		return nil
+	}
+	oldAppGUID, err := m.appRepo.GetAppGuid(m.venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := m.appRepo.GetAppGuid(m.appName)
+	if err != nil {
+		return err
+	}
+
+	m.old, err = m.appRepo.GetNetworkPolicies(oldAppGUID)
+	if err != nil {
+		return err
+	}
+	if len(m.old) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Copying %d network policies from %s to new %s\n",
+		len(m.old),
+		terminal.EntityNameColor(m.venerableName),
+		terminal.EntityNameColor(m.appName),
+	)
+	policies := make([]NetworkPolicy, 0, len(m.old))
+	for _, policy := range m.old {
+		if policy.Source.ID == oldAppGUID {
+			policy.Source.ID = newAppGUID
+		}
+		if policy.Destination.ID == oldAppGUID {
+			policy.Destination.ID = newAppGUID
+		}
+		policies = append(policies, policy)
+	}
+	if err := m.appRepo.CreateNetworkPolicies(policies); err != nil {
+		fmt.Println("FAILED")
+		return err
+	}
+	m.created = policies
+	fmt.Println("OK")
+	return nil
+}
+
+// Revert removes the policies created for the new app.
+func (m *networkPolicyMigration) Revert() error {
+	err := m.appRepo.DeleteNetworkPolicies(m.created)
+	if err == nil {
+		m.created = nil
+	}
+	return err
+}
+
+// Cleanup removes the policies of the venerable app.
+func (m *networkPolicyMigration) Cleanup() error {
+	err := m.appRepo.DeleteNetworkPolicies(m.old)
+	if err == nil {
+		m.old = nil
+	}
+	return err
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+type Process struct {
+	GUID        string `json:"guid"`
+	Type        string `json:"type"`
+	Instances   int    `json:"instances"`
+	MemoryInMB  int64  `json:"memory_in_mb"`
+	DiskInMB    int64  `json:"disk_in_mb"`
+	HealthCheck struct {
+		Type string `json:"type"`
+		Data struct {
+			Timeout           int    `json:"timeout"`
+			InvocationTimeout int    `json:"invocation_timeout"`
+			Endpoint          string `json:"endpoint"`
+		} `json:"data"`
+	} `json:"health_check"`
+}
+
+type ProcessInstanceStats struct {
+	Index int    `json:"index"`
+	State string `json:"state"`
+	Usage struct {
+		CPU  float64 `json:"cpu"`
+		Mem  int64   `json:"mem"`
+		Disk int64   `json:"disk"`
+	} `json:"usage"`
+	MemQuota  int64 `json:"mem_quota"`
+	DiskQuota int64 `json:"disk_quota"`
+}
+
+func (repo *ApplicationRepo) GetProcesses(appGUID string) ([]Process, error) {
+	var resp struct {
+		Resources []Process `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/apps/%s/processes?per_page=5000", appGUID), nil)
+	return resp.Resources, err
+}
+
+func (repo *ApplicationRepo) GetProcessStats(processGUID string) ([]ProcessInstanceStats, error) {
+	var resp struct {
+		Resources []ProcessInstanceStats `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/processes/%s/stats", processGUID), nil)
+	return resp.Resources, err
+}
+
+func (repo *ApplicationRepo) ScaleProcess(processGUID string, instances int) error {
+	body := map[string]int{"instances": instances}
+	return repo.curl(nil, "POST", fmt.Sprintf("/v3/processes/%s/actions/scale", processGUID), body)
+}
+
+// matchScale scales each process of the app to the live number of instances
+// of the same process type of the reference app, e.g. as changed by an
+// autoscaler. It returns a function scaling them back.
+func matchScale(appRepo *ApplicationRepo, appName, referenceName string) (func() error, error) {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return nil, err
+	}
+	referenceGUID, err := appRepo.GetAppGuid(referenceName)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := appRepo.GetProcesses(appGUID)
+	if err != nil {
+		return nil, err
+	}
+	references, err := appRepo.GetProcesses(referenceGUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var scaled []Process
+	scaleBack := func() error {
+		for _, process := range scaled {
+			if err := appRepo.ScaleProcess(process.GUID, process.Instances); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, process := range processes {
+		for _, reference := range references {
+			if reference.Type != process.Type || reference.Instances == process.Instances {
+				continue
+			}
+			fmt.Printf("Scaling process %s of %s to %d instances, as %s\n",
+				process.Type, terminal.EntityNameColor(appName), reference.Instances, terminal.EntityNameColor(referenceName))
+			if err := appRepo.ScaleProcess(process.GUID, reference.Instances); err != nil {
+				fmt.Println("FAILED")
+				return scaleBack, err
+			}
+			fmt.Println("OK")
+			scaled = append(scaled, process)
+		}
+	}
+	return scaleBack, nil
+}
+
+// processScaleKeys are the process settings of the manifest replaced by
+// their live values. The command is left out: the processes list redacts it,
+// and create-app-manifest already exports a command set by the user.
+var processScaleKeys = []string{
+	"instances", "memory", "disk_quota", "timeout",
+	"health-check-type", "health-check-http-endpoint", "health-check-invocation-timeout",
+}
+
+// withLiveScale returns a manifest edit patching the process settings of
+// the exported manifest, which reflect the configured scale, with the live
+// ones of the given processes (e.g. as changed by an autoscaler). The other
+// settings of the exported processes are kept as they are.
+func withLiveScale(processes []Process) func(app ManifestApp) error {
+	return func(app ManifestApp) error {
+		entries, _ := app["processes"].([]interface{})
+		for _, process := range processes {
+			entry := manifestProcess(entries, process.Type)
+			switch {
+			case entry == nil && process.Type == "web":
+				// described by the application itself
+				entry = app
+			case entry == nil:
+				entry = map[interface{}]interface{}{"type": process.Type}
+				entries = append(entries, entry)
+			}
+			entry["instances"] = process.Instances
+			entry["memory"] = fmt.Sprintf("%dM", process.MemoryInMB)
+			entry["disk_quota"] = fmt.Sprintf("%dM", process.DiskInMB)
+			entry["health-check-type"] = process.HealthCheck.Type
+			setOrDelete(entry, "timeout", process.HealthCheck.Data.Timeout)
+			setOrDelete(entry, "health-check-invocation-timeout", process.HealthCheck.Data.InvocationTimeout)
+			setOrDelete(entry, "health-check-http-endpoint", process.HealthCheck.Data.Endpoint)
+		}
+		if len(entries) > 0 {
+			app["processes"] = entries
+		}
+		// the settings of the application would conflict with those of its
+		// web process
+		if manifestProcess(entries, "web") != nil {
+			for _, key := range processScaleKeys {
+				delete(app, key)
+			}
+		}
+		return nil
+	}
+}
+
+// manifestProcess returns the entry of the process type in the processes
+// of the manifest, if any.
+func manifestProcess(entries []interface{}, processType string) map[interface{}]interface{} {
+	for _, e := range entries {
+		entry, ok := e.(map[interface{}]interface{})
+		if ok && entry["type"] == processType {
+			return entry
+		}
+	}
+	return nil
+}
+
+// setOrDelete sets the key of the manifest entry, or deletes it for an
+// empty string or a zero.
+func setOrDelete(entry map[interface{}]interface{}, key string, value interface{}) {
+	if value == "" || value == 0 {
+		delete(entry, key)
+	} else {
+		entry[key] = value
+	}
+}
+
+// verifyProcesses waits until every process type of the new app runs as
+// many instances as the same process type of the venerable app.
+func verifyProcesses(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	oldProcesses, err := appRepo.GetProcesses(oldAppGUID)
+	if err != nil {
+		return err
+	}
+	newProcesses, err := appRepo.GetProcesses(newAppGUID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Waiting for all processes of %s to be running\n", terminal.EntityNameColor(appName))
+	for _, oldProcess := range oldProcesses {
+		var newProcess *Process
+		for i := range newProcesses {
+			if newProcesses[i].Type == oldProcess.Type {
+				newProcess = &newProcesses[i]
+			}
+		}
+		if newProcess == nil {
+			fmt.Println("FAILED")
+			return fmt.Errorf("process type '%s' of %s is missing on %s", oldProcess.Type, venerableName, appName)
+		}
+
+		lastRunning := -1
+		for {
+			stats, err := appRepo.GetProcessStats(newProcess.GUID)
+			if err != nil {
+				fmt.Println("FAILED")
+				return err
+			}
+			running, states := 0, make([]string, 0, len(stats))
+			for _, instance := range stats {
+				switch instance.State {
+				case "RUNNING":
+					running++
+				case "CRASHED":
+					fmt.Println("FAILED")
+					return fmt.Errorf("instance %d of process '%s' crashed", instance.Index, newProcess.Type)
+				}
+				states = append(states, instance.State)
+			}
+			if running != lastRunning {
+				fmt.Printf("   %s: %d of %d instances running\n", newProcess.Type, running, oldProcess.Instances)
+				lastRunning = running
+			}
+			if running >= oldProcess.Instances {
+				break
+			}
+			if err := sleep(ctx, 2*time.Second); err != nil {
+				fmt.Println("FAILED")
+				return errors.Wrapf(err, "process '%s' has %d of %d instances running (%s)",
+					newProcess.Type, running, oldProcess.Instances, strings.Join(states, ", "))
+			}
+		}
+	}
+	fmt.Println("OK")
+	return nil
+}
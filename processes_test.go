@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func liveProcess(processType string, instances int, memory, disk int64, healthCheck, endpoint string) Process {
+	p := Process{Type: processType, Instances: instances, MemoryInMB: memory, DiskInMB: disk}
+	p.HealthCheck.Type = healthCheck
+	p.HealthCheck.Data.Endpoint = endpoint
+	return p
+}
+
+func TestWithLiveScale(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifest  string
+		processes []Process
+		want      string
+	}{
+		{
+			name: "process entries",
+			manifest: `
+applications:
+- name: myapp
+  buildpacks:
+  - java_buildpack
+  env:
+    JAVA_OPTS: -Xss512k
+  processes:
+  - type: web
+    command: java -jar app.jar
+    disk_quota: 1024M
+    health-check-http-endpoint: /health
+    health-check-type: http
+    instances: 2
+    memory: 1024M
+    timeout: 60
+  - type: worker
+    disk_quota: 1024M
+    health-check-type: process
+    instances: 1
+    memory: 512M
+  stack: cflinuxfs4
+`,
+			processes: []Process{
+				liveProcess("web", 6, 1024, 1024, "http", "/health"),
+				liveProcess("worker", 3, 768, 1024, "process", ""),
+			},
+			want: `
+name: myapp
+buildpacks:
+- java_buildpack
+env:
+  JAVA_OPTS: -Xss512k
+processes:
+- type: web
+  command: java -jar app.jar
+  disk_quota: 1024M
+  health-check-http-endpoint: /health
+  health-check-type: http
+  instances: 6
+  memory: 1024M
+- type: worker
+  disk_quota: 1024M
+  health-check-type: process
+  instances: 3
+  memory: 768M
+stack: cflinuxfs4
+`,
+		},
+		{
+			name: "application keys",
+			manifest: `
+applications:
+- name: myapp
+  command: ./run.sh
+  disk_quota: 1G
+  instances: 2
+  memory: 256M
+  stack: cflinuxfs4
+`,
+			processes: []Process{
+				liveProcess("web", 4, 256, 1024, "port", ""),
+				liveProcess("worker", 1, 128, 512, "process", ""),
+			},
+			want: `
+name: myapp
+command: ./run.sh
+disk_quota: 1024M
+health-check-type: port
+instances: 4
+memory: 256M
+processes:
+- type: worker
+  disk_quota: 512M
+  health-check-type: process
+  instances: 1
+  memory: 128M
+stack: cflinuxfs4
+`,
+		},
+		{
+			name: "application keys and web entry",
+			manifest: `
+applications:
+- name: myapp
+  instances: 2
+  memory: 256M
+  processes:
+  - type: web
+    instances: 2
+    memory: 256M
+`,
+			processes: []Process{
+				liveProcess("web", 5, 512, 1024, "port", ""),
+			},
+			want: `
+name: myapp
+processes:
+- type: web
+  disk_quota: 1024M
+  health-check-type: port
+  instances: 5
+  memory: 512M
+`,
+		},
+	}
+	for _, test := range tests {
+		appRepo := &ApplicationRepo{dir: t.TempDir()}
+		if err := os.WriteFile(filepath.Join(appRepo.dir, manifestFileName), []byte(test.manifest), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := appRepo.EditManifest(withLiveScale(test.processes)); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(appRepo.dir, manifestFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest struct {
+			Applications []map[interface{}]interface{} `yaml:"applications"`
+		}
+		if err := yaml.Unmarshal(data, &manifest); err != nil || len(manifest.Applications) != 1 {
+			t.Fatalf("%s: unexpected manifest %s", test.name, data)
+		}
+		var want map[interface{}]interface{}
+		if err := yaml.Unmarshal([]byte(test.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(manifest.Applications[0], want) {
+			t.Errorf("%s: got manifest\n%s", test.name, data)
+		}
+	}
+}
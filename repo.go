@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,14 +17,16 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	dropletFileName  = "droplet"
-	manifestFileName = "manifest.yml"
-)
+const manifestFileName = "manifest.yml"
 
 type ApplicationRepo struct {
-	conn plugin.CliConnection
-	dir  string
+	conn     plugin.CliConnection
+	dir      string
+	retry    retryPolicy
+	throttle *throttle
+	// ctx stops the waits between calls during the forward steps of an
+	// operation; nil otherwise, so that a rollback always completes
+	ctx context.Context
 }
 
 func NewApplicationRepo(conn plugin.CliConnection) (*ApplicationRepo, error) {
@@ -28,28 +34,76 @@ func NewApplicationRepo(conn plugin.CliConnection) (*ApplicationRepo, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "creating temporary directory")
 	}
-	err = os.WriteFile(filepath.Join(dir, ".cfignore"), ([]byte)(dropletFileName+"\n"), 0600)
-	if err != nil {
-		return nil, errors.Wrap(err, "creating .cfignore")
-	}
 	f, err := os.Create(filepath.Join(dir, ".app_bits_placeholder"))
 	if err == nil {
 		defer f.Close()
 	}
 
 	return &ApplicationRepo{
-		conn: conn,
-		dir:  dir,
+		conn:     conn,
+		dir:      dir,
+		retry:    defaultRetryPolicy,
+		throttle: newThrottle(0, 0),
 	}, nil
 }
 
+// context returns the context of the calls to the Cloud Controller.
+func (repo *ApplicationRepo) context() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
+// call makes a call to the Cloud Controller, throttled and retried on
+// transient errors.
+func (repo *ApplicationRepo) call(description string, f func() ([]string, error)) ([]string, error) {
+	return repo.retry.do(repo.context(), description, isTransient, func() ([]string, error) {
+		if err := repo.throttle.Wait(repo.context()); err != nil {
+			return nil, context.Cause(repo.context())
+		}
+		output, err := f()
+		if isRateLimited(err, output) {
+			repo.throttle.SlowDown()
+		}
+		return output, err
+	})
+}
+
+// cliCommand runs a cf CLI command, throttled and retried on transient
+// errors.
+func (repo *ApplicationRepo) cliCommand(args ...string) ([]string, error) {
+	return repo.call(describeCommand(args), func() ([]string, error) {
+		return repo.conn.CliCommand(args...)
+	})
+}
+
+// cliCommandWithoutTerminalOutput runs a cf CLI command without showing its
+// output, throttled and retried on transient errors.
+func (repo *ApplicationRepo) cliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	return repo.call(describeCommand(args), func() ([]string, error) {
+		return repo.conn.CliCommandWithoutTerminalOutput(args...)
+	})
+}
+
+func describeCommand(args []string) string {
+	if args[0] == "curl" {
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "/") {
+				return "cf curl " + arg
+			}
+		}
+	}
+	return "cf " + args[0]
+}
+
 func (repo *ApplicationRepo) DeleteDir() error {
 	return os.RemoveAll(repo.dir)
 }
 
 func (repo *ApplicationRepo) CreateManifest(appName string) error {
 	// FIXME: this function should use the appGUID instead
-	_, err := repo.conn.CliCommand("create-app-manifest", appName, "-p", repo.manifestFilePath())
+	_, err := repo.cliCommand("create-app-manifest", appName, "-p", repo.manifestFilePath())
 	return err
 }
 
@@ -59,84 +113,48 @@ func (repo *ApplicationRepo) manifestFilePath() string {
 
 func (repo *ApplicationRepo) RenameApplication(oldName, newName string) error {
 	// FIXME: this function should use the old appGUID instead
-	_, err := repo.conn.CliCommand("rename", oldName, newName)
+	_, err := repo.cliCommand("rename", oldName, newName)
 	return err
 }
 
 func (repo *ApplicationRepo) PushApplication(appName string) error {
-	_, err := repo.conn.CliCommand("push", appName, "-f", repo.manifestFilePath(), "-p", repo.dir, "--no-start")
-	return err
-}
-
-func (repo *ApplicationRepo) DownloadDroplet(appGUID string) error {
-	_, err := repo.conn.CliCommandWithoutTerminalOutput(
-		"curl",
-		fmt.Sprintf("/v2/apps/%s/droplet/download", appGUID),
-		"--output",
-		repo.dropletFilePath(),
-	)
+	_, err := repo.cliCommand("push", appName, "-f", repo.manifestFilePath(), "-p", repo.dir, "--no-start")
 	return err
 }
 
-func (repo *ApplicationRepo) UploadDroplet(appName string) error {
-	// FIXME: this unfortunately overrides/resets the buildpack and stack
-	// setting on the cloud controller, even if they were set correctly via
-	// the manifest
-	// FIXME: this function should use the appGUID instead
-	// FIXME: the following line is broken due to https://github.com/cloudfoundry/cli/issues/1445 so we shell out instead
-	// _, err := repo.conn.CliCommand("push", appName, "--no-manifest", "--droplet", repo.dropletFilePath(), "--no-start")
-	fmt.Println("Uploading droplet")
-	err := exec.Command("cf", "push", appName, "--no-manifest", "--droplet", repo.dropletFilePath(), "--no-start").Run()
-	if err == nil {
-		fmt.Println("OK")
-	} else {
-		fmt.Println("FAILED")
-	}
+// PushDockerApplication pushes the app from the Docker image of the
+// manifest.
+func (repo *ApplicationRepo) PushDockerApplication(appName string) error {
+	_, err := repo.cliCommand("push", appName, "-f", repo.manifestFilePath(), "--no-start")
 	return err
 }
 
-func (repo *ApplicationRepo) dropletFilePath() string {
-	return filepath.Join(repo.dir, dropletFileName)
-}
-
-func (repo *ApplicationRepo) StartApplication(appName string) error {
-	// FIXME: this function should use the appGUID instead
-	_, err := repo.conn.CliCommand("start", appName)
-	return err
+// StartApplication starts the app through the Cloud Controller API. Unlike
+// cf start, it neither stages the app nor waits for its instances.
+func (repo *ApplicationRepo) StartApplication(appGUID string) error {
+	return repo.curl(nil, "POST", fmt.Sprintf("/v3/apps/%s/actions/start", appGUID), nil)
 }
 
 func (repo *ApplicationRepo) StopApplication(appName string) error {
 	// FIXME: this function should use the appGUID instead
-	_, err := repo.conn.CliCommand("stop", appName)
+	_, err := repo.cliCommand("stop", appName)
 	return err
 }
 
 func (repo *ApplicationRepo) DeleteApplication(appName string) error {
 	// FIXME: this function should use the appGUID instead
-	_, err := repo.conn.CliCommand("delete", appName, "-f")
+	_, err := repo.cliCommand("delete", appName, "-f")
 	return err
 }
 
 func (repo *ApplicationRepo) ListApplications() error {
-	_, err := repo.conn.CliCommand("apps")
+	_, err := repo.cliCommand("apps")
 	return err
 }
 
 func (repo *ApplicationRepo) CopyBits(oldAppGuid, newAppGuid string) (Job, error) {
-	respSlice, err := repo.conn.CliCommandWithoutTerminalOutput(
-		"curl",
-		"-X",
-		"POST",
-		fmt.Sprintf("/v2/apps/%s/copy_bits", newAppGuid),
-		"-d",
-		fmt.Sprintf(`{"source_app_guid":"%s"}`, oldAppGuid),
-	)
-	if err != nil {
-		return Job{}, err
-	}
-	resp := strings.Join(respSlice, "\n")
 	var job Job
-	err = json.Unmarshal([]byte(resp), &job)
+	err := repo.curl(&job, "POST", fmt.Sprintf("/v2/apps/%s/copy_bits", newAppGuid), map[string]string{"source_app_guid": oldAppGuid})
 	if err != nil {
 		return Job{}, err
 	}
@@ -144,21 +162,97 @@ func (repo *ApplicationRepo) CopyBits(oldAppGuid, newAppGuid string) (Job, error
 }
 
 func (repo *ApplicationRepo) GetJob(jobGuid string) (Job, error) {
-	respSlice, err := repo.conn.CliCommandWithoutTerminalOutput(
-		"curl",
-		fmt.Sprintf("/v2/jobs/%s", jobGuid),
-	)
-	resp := strings.Join(respSlice, "\n")
 	var job Job
-	err = json.Unmarshal([]byte(resp), &job)
+	err := repo.curl(&job, "GET", fmt.Sprintf("/v2/jobs/%s", jobGuid), nil)
 	if err != nil {
 		return Job{}, err
 	}
 	return job, nil
 }
 
+// curl sends a request through "cf curl" and decodes the JSON response into
+// result, unless result is nil. Error documents returned by the Cloud
+// Controller or the APIs it fronts are turned into an *APIError. Requests
+// are throttled according to the rate limit headers of the responses, and
+// retried when failing with a transient error.
+func (repo *ApplicationRepo) curl(result interface{}, method, path string, body interface{}) error {
+	args := []string{"curl", "-i", "-X", method, path}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "encoding request body")
+		}
+		args = append(args, "-d", string(data))
+	}
+	retryable := isTransient
+	if createsResource(method, path) {
+		retryable = isUnprocessed
+	}
+	var resp []byte
+	_, err := repo.retry.do(repo.context(), describeCommand(args), retryable, func() ([]string, error) {
+		if err := repo.throttle.Wait(repo.context()); err != nil {
+			return nil, context.Cause(repo.context())
+		}
+		respSlice, err := repo.conn.CliCommandWithoutTerminalOutput(args...)
+		if err != nil {
+			return respSlice, err
+		}
+		var status int
+		var header http.Header
+		status, header, resp = parseHTTPResponse(respSlice)
+		repo.throttle.Observe(status, header)
+
+		// failed builds and droplets report their error in the body of a
+		// successful response
+		if apiErr := parseAPIError(resp); apiErr != nil && (status == 0 || status >= 400) {
+			apiErr.Status = status
+			return respSlice, apiErr
+		}
+		if status >= 400 || (len(bytes.TrimSpace(resp)) > 0 && !json.Valid(resp)) {
+			// typically an error page from the gorouter
+			line, _, _ := strings.Cut(strings.TrimSpace(string(resp)), "\n")
+			return respSlice, &APIError{Status: status, Description: "unexpected response: " + line}
+		}
+		return respSlice, nil
+	})
+	if err != nil {
+		return err
+	}
+	if result == nil || len(strings.TrimSpace(string(resp))) == 0 {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(resp, result), "decoding response of %s %s", method, path)
+}
+
+// parseHTTPResponse splits the output of "cf curl -i" into the status code,
+// headers and body of the response. The whole output is considered as body
+// when it does not start with a status line.
+func parseHTTPResponse(lines []string) (int, http.Header, []byte) {
+	header := http.Header{}
+	status := 0
+	// skip interim responses, such as "100 Continue"
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "HTTP/") {
+		fields := strings.Fields(lines[0])
+		if len(fields) > 1 {
+			status, _ = strconv.Atoi(fields[1])
+		}
+		lines = lines[1:]
+		for len(lines) > 0 && !strings.HasPrefix(lines[0], "HTTP/") {
+			line := strings.TrimRight(lines[0], "\r")
+			lines = lines[1:]
+			if line == "" {
+				break
+			}
+			if key, value, ok := strings.Cut(line, ":"); ok {
+				header.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+			}
+		}
+	}
+	return status, header, []byte(strings.Join(lines, "\n"))
+}
+
 func (repo *ApplicationRepo) GetAppGuid(name string) (string, error) {
-	d, err := repo.conn.CliCommandWithoutTerminalOutput("app", name, "--guid")
+	d, err := repo.cliCommandWithoutTerminalOutput("app", name, "--guid")
 	if err != nil {
 		return "", err
 	}
@@ -168,12 +262,31 @@ func (repo *ApplicationRepo) GetAppGuid(name string) (string, error) {
 	return d[0], err
 }
 
+// DoesAppExist tells whether an app of that name exists in the current
+// space. Unlike cf app, it does not fail when the app does not exist.
 func (repo *ApplicationRepo) DoesAppExist(appName string) (bool, error) {
-	d, err := repo.conn.CliCommandWithoutTerminalOutput("app", appName, "--guid")
+	space, err := repo.conn.GetCurrentSpace()
 	if err != nil {
 		return false, err
 	}
-	return len(d) > 0, nil
+	query := url.Values{}
+	query.Set("names", appName)
+	query.Set("space_guids", space.Guid)
+	var resp struct {
+		Resources []struct {
+			Name string `json:"name"`
+		} `json:"resources"`
+	}
+	if err := repo.curl(&resp, "GET", "/v3/apps?"+query.Encode(), nil); err != nil {
+		return false, err
+	}
+	// the names filter splits names on commas
+	for _, app := range resp.Resources {
+		if app.Name == appName {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 type Job struct {
@@ -193,3 +306,48 @@ type Job struct {
 		} `json:"error_details"`
 	} `json:"entity"`
 }
+
+// APIError is an error document returned by the Cloud Controller (v2 or v3)
+// or by the network policy API.
+type APIError struct {
+	Status      int // HTTP status code, when known
+	Code        int
+	ErrorCode   string
+	Description string
+}
+
+func (e *APIError) Error() string {
+	if e.ErrorCode == "" {
+		return fmt.Sprintf("Error %s [status code: %d]", e.Description, e.Status)
+	}
+	return fmt.Sprintf("Error %s, %s [code: %d]", e.ErrorCode, e.Description, e.Code)
+}
+
+func parseAPIError(resp []byte) *APIError {
+	var doc struct {
+		// v3
+		Errors []struct {
+			Code   int    `json:"code"`
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+		// v2
+		Code        int    `json:"code"`
+		ErrorCode   string `json:"error_code"`
+		Description string `json:"description"`
+		// network policy API
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(resp, &doc) != nil {
+		return nil
+	}
+	switch {
+	case len(doc.Errors) > 0:
+		return &APIError{Code: doc.Errors[0].Code, ErrorCode: doc.Errors[0].Title, Description: doc.Errors[0].Detail}
+	case doc.ErrorCode != "":
+		return &APIError{Code: doc.Code, ErrorCode: doc.ErrorCode, Description: doc.Description}
+	case doc.Error != "":
+		return &APIError{Description: doc.Error}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseHTTPResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		status int
+		header map[string]string
+		body   string
+	}{
+		{
+			name:   "response",
+			lines:  []string{"HTTP/1.1 200 OK", "Content-Type: application/json", "X-Ratelimit-Remaining: 90", "", `{"guid":"a"}`},
+			status: 200,
+			header: map[string]string{"Content-Type": "application/json", "X-RateLimit-Remaining": "90"},
+			body:   `{"guid":"a"}`,
+		},
+		{
+			name:   "carriage returns",
+			lines:  []string{"HTTP/1.1 404 Not Found\r", "Content-Type: application/json\r", "\r", `{"errors":[]}`},
+			status: 404,
+			header: map[string]string{"Content-Type": "application/json"},
+			body:   `{"errors":[]}`,
+		},
+		{
+			name:   "interim response",
+			lines:  []string{"HTTP/1.1 100 Continue", "", "HTTP/1.1 201 Created", "Location: /v3/apps/a", "", `{"guid":"a"}`},
+			status: 201,
+			header: map[string]string{"Location": "/v3/apps/a"},
+			body:   `{"guid":"a"}`,
+		},
+		{
+			name:   "interim response with headers",
+			lines:  []string{"HTTP/1.1 100 Continue", "X-Vcap-Request-Id: 1", "", "HTTP/1.1 202 Accepted", "", ""},
+			status: 202,
+			header: map[string]string{"X-Vcap-Request-Id": "1"},
+			body:   "",
+		},
+		{
+			name:   "interim response without blank line",
+			lines:  []string{"HTTP/1.1 100 Continue", "HTTP/1.1 200 OK", "X-Vcap-Request-Id: 1", "", `{"guid":"a"}`},
+			status: 200,
+			header: map[string]string{"X-Vcap-Request-Id": "1"},
+			body:   `{"guid":"a"}`,
+		},
+		{
+			name:   "no body",
+			lines:  []string{"HTTP/1.1 204 No Content", "X-Vcap-Request-Id: 2"},
+			status: 204,
+			header: map[string]string{"X-Vcap-Request-Id": "2"},
+			body:   "",
+		},
+		{
+			name:   "multiline body",
+			lines:  []string{"HTTP/1.1 200 OK", "", "{", `  "guid": "a"`, "}"},
+			status: 200,
+			body:   "{\n  \"guid\": \"a\"\n}",
+		},
+		{
+			name:  "no status line",
+			lines: []string{`{"guid":"a"}`},
+			body:  `{"guid":"a"}`,
+		},
+		{
+			name:  "gorouter error page",
+			lines: []string{"502 Bad Gateway: Registered endpoint failed to handle the request."},
+			body:  "502 Bad Gateway: Registered endpoint failed to handle the request.",
+		},
+	}
+	for _, test := range tests {
+		status, header, body := parseHTTPResponse(test.lines)
+		if status != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, status, test.status)
+		}
+		for key, value := range test.header {
+			if got := header.Get(key); got != value {
+				t.Errorf("%s: got header %s %q, want %q", test.name, key, got, value)
+			}
+		}
+		if string(body) != test.body {
+			t.Errorf("%s: got body %q, want %q", test.name, body, test.body)
+		}
+	}
+}
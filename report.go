@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// Stack is a stack installed on the platform.
+type Stack struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	// State is ACTIVE, DEPRECATED, RESTRICTED or DISABLED, on the Cloud
+	// Controllers supporting it
+	State string `json:"state"`
+}
+
+func (repo *ApplicationRepo) GetStacks() ([]Stack, error) {
+	var resp struct {
+		Resources []Stack `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", "/v3/stacks?per_page=5000", nil)
+	return resp.Resources, err
+}
+
+// stackVersionPattern splits the name of a stack into its family and its
+// version, e.g. cflinuxfs and 4.
+var stackVersionPattern = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// hasNewerStack tells whether the app should move to a newer stack: its
+// stack is deprecated, or is an older one of the family of the default
+// stack, e.g. cflinuxfs3 when cflinuxfs4 is the default. Other stacks, e.g.
+// Windows ones, are not comparable with the default one.
+func hasNewerStack(name string, stacks []Stack) bool {
+	var current, defaultStack *Stack
+	for i := range stacks {
+		if stacks[i].Name == name {
+			current = &stacks[i]
+		}
+		if stacks[i].Default {
+			defaultStack = &stacks[i]
+		}
+	}
+	if current != nil && current.State == "DEPRECATED" {
+		return true
+	}
+	if defaultStack == nil {
+		return false
+	}
+	stack := stackVersionPattern.FindStringSubmatch(name)
+	latest := stackVersionPattern.FindStringSubmatch(defaultStack.Name)
+	if stack == nil || latest == nil || stack[1] != latest[1] {
+		return false
+	}
+	version, _ := strconv.Atoi(stack[2])
+	latestVersion, _ := strconv.Atoi(latest[2])
+	return version < latestVersion
+}
+
+// reportRow describes an app in the inventory of bg-report.
+type reportRow struct {
+	Org               string `json:"org"`
+	Space             string `json:"space"`
+	Name              string `json:"name"`
+	State             string `json:"state"`
+	Stack             string `json:"stack"`
+	Buildpack         string `json:"buildpack"`
+	BuildpackVersion  string `json:"buildpack_version"`
+	DropletAge        string `json:"droplet_age"`
+	NewerBuildpack    bool   `json:"newer_buildpack"`
+	NewerStack        bool   `json:"newer_stack"`
+	Eligible          bool   `json:"eligible"`
+	NotEligibleReason string `json:"not_eligible_reason,omitempty"`
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// report lists the apps of the selected orgs and spaces with the state of
+// their droplet, to plan restaging campaigns. It changes nothing.
+func (BgRestagePlugin) report(cliConnection plugin.CliConnection, args []string) error {
+	fs := flag.NewFlagSet("cf bg-report", flag.ExitOnError)
+	org := fs.Bool("org", false, "Report on the apps of the whole current org instead of the current space")
+	orgs := fs.String("orgs", "", "Comma separated names of the orgs to report on, instead of the current space")
+	all := fs.Bool("all", false, "Report on the apps of all the orgs")
+	spaces := fs.String("spaces", "", "Comma separated names of the spaces to report on, within the selected orgs")
+	format := fs.String("format", "table", "Output format: table, csv or json")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+	if *format != "table" && *format != "csv" && *format != "json" {
+		fs.Usage()
+		return fmt.Errorf("illegal --format")
+	}
+
+	appRepo, err := NewApplicationRepo(cliConnection)
+	if err != nil {
+		return err
+	}
+	defer appRepo.DeleteDir()
+
+	scope, err := appRepo.selectScope(*org, *orgs, *all, *spaces)
+	if err != nil {
+		return err
+	}
+	apps, err := appRepo.ListApps(scope)
+	if err != nil {
+		return err
+	}
+	buildpacks, err := appRepo.GetBuildpacks()
+	if err != nil {
+		return err
+	}
+	stacks, err := appRepo.GetStacks()
+	if err != nil {
+		return err
+	}
+	var rows []reportRow
+	for _, app := range apps {
+		if !inSpaces(app, *spaces) {
+			continue
+		}
+		row, err := reportApp(appRepo, app, buildpacks, stacks)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+
+	switch *format {
+	case "json":
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"org", "space", "name", "state", "stack", "buildpack", "buildpack version", "droplet age", "newer buildpack", "newer stack", "eligible", "not eligible reason"})
+		for _, row := range rows {
+			w.Write([]string{row.Org, row.Space, row.Name, row.State, row.Stack, row.Buildpack, row.BuildpackVersion, row.DropletAge,
+				yesNo(row.NewerBuildpack), yesNo(row.NewerStack), yesNo(row.Eligible), row.NotEligibleReason})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	if len(rows) == 0 {
+		fmt.Println("No application found")
+		return nil
+	}
+	table := terminal.NewTable([]string{"org", "space", "name", "state", "stack", "buildpack", "version", "droplet age", "newer buildpack", "newer stack", "eligible"})
+	for _, row := range rows {
+		eligible := yesNo(row.Eligible)
+		if !row.Eligible {
+			eligible += ": " + row.NotEligibleReason
+		}
+		table.Add(row.Org, row.Space, row.Name, row.State, row.Stack, row.Buildpack, row.BuildpackVersion, row.DropletAge,
+			yesNo(row.NewerBuildpack), yesNo(row.NewerStack), eligible)
+	}
+	return table.PrintTo(os.Stdout)
+}
+
+// reportApp describes the app and its current droplet.
+func reportApp(appRepo *ApplicationRepo, app App, buildpacks []Buildpack, stacks []Stack) (reportRow, error) {
+	row := reportRow{
+		Org:   app.OrgName,
+		Space: app.SpaceName,
+		Name:  app.Name,
+		State: strings.ToLower(app.State),
+		Stack: app.Lifecycle.Data.Stack,
+	}
+	droplet, err := appRepo.GetCurrentDroplet(app.GUID)
+	if err != nil {
+		return reportRow{}, err
+	}
+	if droplet != nil {
+		if droplet.Stack != "" {
+			row.Stack = droplet.Stack
+		}
+		var names, versions []string
+		for _, buildpack := range droplet.Buildpacks {
+			name := buildpack.BuildpackName
+			if name == "" {
+				name = buildpack.Name
+			}
+			names = append(names, name)
+			versions = append(versions, buildpack.Version)
+		}
+		row.Buildpack = strings.Join(names, ", ")
+		row.BuildpackVersion = strings.Join(versions, ", ")
+		row.DropletAge = formatAge(time.Since(droplet.CreatedAt))
+		row.NewerBuildpack = app.Lifecycle.Type != dockerLifecycle && !stagedWithLatest(droplet, buildpacks)
+	}
+	row.NewerStack = hasNewerStack(row.Stack, stacks)
+
+	switch {
+	case app.Metadata.Annotations[venerableAnnotation] != "":
+		row.NotEligibleReason = "old copy of " + app.Metadata.Annotations[venerableAnnotation]
+	case app.Metadata.Annotations[lockAnnotation] != "":
+		row.NotEligibleReason = "locked"
+	case droplet == nil:
+		row.NotEligibleReason = "not staged"
+	default:
+		row.Eligible = true
+	}
+	return row, nil
+}
+
+// formatAge formats a duration in days, or in hours under a day.
+func formatAge(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestHasNewerStack(t *testing.T) {
+	stacks := []Stack{
+		{Name: "cflinuxfs3", State: "DEPRECATED"},
+		{Name: "cflinuxfs4", Default: true, State: "ACTIVE"},
+		{Name: "cflinuxfs5", State: "ACTIVE"},
+		{Name: "windows", State: "ACTIVE"},
+		{Name: "windows2016", State: "DEPRECATED"},
+	}
+	tests := []struct {
+		stacks []Stack
+		stack  string
+		newer  bool
+	}{
+		{stacks, "cflinuxfs3", true},
+		{stacks, "cflinuxfs4", false},
+		{stacks, "cflinuxfs5", false},
+		{stacks, "windows", false},
+		{stacks, "windows2016", true},
+		{stacks, "", false},
+		// without stack states
+		{[]Stack{{Name: "cflinuxfs3"}, {Name: "cflinuxfs4", Default: true}, {Name: "windows"}}, "cflinuxfs3", true},
+		{[]Stack{{Name: "cflinuxfs3"}, {Name: "cflinuxfs4", Default: true}, {Name: "windows"}}, "windows", false},
+		{[]Stack{{Name: "cflinuxfs3"}, {Name: "cflinuxfs4"}}, "cflinuxfs3", false},
+	}
+	for _, test := range tests {
+		if got := hasNewerStack(test.stack, test.stacks); got != test.newer {
+			t.Errorf("stack %q among %v: got newer stack %t, want %t", test.stack, test.stacks, got, test.newer)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -9,105 +10,215 @@ import (
 	"github.com/contraband/autopilot/rewind"
 )
 
-func restageActions(appRepo *ApplicationRepo, appName string, cleanup cleanupAction) []rewind.Action {
+func restageActions(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string, docker bool, opts options, lock *appLock) []rewind.Action {
+	policies := &networkPolicyMigration{appRepo: appRepo, appName: appName, venerableName: venerableName, skip: opts.skipNetworkPolicies}
+	// reverse drops the new app and gives the venerable app its name back
+	reverse := func() error {
+		policies.Revert()
+		appRepo.DeleteApplication(appName)
+		return restoreFromVenerable(appRepo, appName, venerableName)
+	}
+
 	return []rewind.Action{
-		// create manifest
+		// create manifest, with the buildpacks to stage the new app with
 		{
 			Forward: func() error {
-				return appRepo.CreateManifest(appName)
+				if len(opts.buildpackOverride) > 0 {
+					return exportManifest(appRepo, appName, withBuildpacks(opts.buildpackOverride))
+				}
+				return exportManifest(appRepo, appName)
 			},
 		},
 		// rename
 		{
 			Forward: func() error {
-				return appRepo.RenameApplication(appName, venerableAppName(appName))
+				return renameToVenerable(appRepo, appName, venerableName)
 			},
 			ReversePrevious: func() error {
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+				return restoreFromVenerable(appRepo, appName, venerableName)
 			},
 		},
 		// push
 		{
 			Forward: func() error {
-				return appRepo.PushApplication(appName)
-			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+				push := appRepo.PushApplication
+				if docker {
+					push = appRepo.PushDockerApplication
+				}
+				if err := push(appName); err != nil {
+					return err
+				}
+				return lock.Add(appName)
 			},
+			ReversePrevious: reverse,
 		},
-		// Copy bits
+		// Copy bits, or the Docker image
 		{
 			Forward: func() error {
-				oldAppGUID, err := appRepo.GetAppGuid(venerableAppName(appName))
-				if err != nil {
-					return err
-				}
-				newAppGUID, err := appRepo.GetAppGuid(appName)
-				if err != nil {
-					return err
-				}
-
-				fmt.Printf("Copying application bits from %s to new %s\n",
-					terminal.EntityNameColor(venerableAppName(appName)),
-					terminal.EntityNameColor(appName),
-				)
-				pb := NewIndeterminateProgressBar(os.Stdout, "")
-
-				job, err := appRepo.CopyBits(oldAppGUID, newAppGUID)
-				if err != nil {
-					return err
-				}
-
-				for {
-					pb.Next()
-					job, err := appRepo.GetJob(job.Entity.GUID)
-					switch {
-					case err != nil:
-						fmt.Println("FAILED")
-						return err
-					case job.Entity.Status == "finished":
-						fmt.Println("OK")
-						return nil
-					case job.Entity.Status == "failed":
-						fmt.Println("FAILED")
-						return fmt.Errorf(
-							"Error %s, %s [code: %d]",
-							job.Entity.ErrorDetails.ErrorCode,
-							job.Entity.ErrorDetails.Description,
-							job.Entity.ErrorDetails.Code,
-						)
-					}
-					time.Sleep(500 * time.Millisecond)
+				if docker {
+					return copyDockerImage(appRepo, appName, venerableName, opts.refreshDockerImage)
 				}
+				return withTimeout(ctx, opts.timeouts.CopyBits, "copying application bits", func(ctx context.Context) error {
+					return copyBits(ctx, appRepo, appName, venerableName)
+				})
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// map routes of old app to new app
+		{
+			Forward: func() error {
+				return copyRoutes(appRepo, appName, venerableName)
+			},
+			ReversePrevious: reverse,
+		},
+		// bind services of old app to new app
+		{
+			Forward: func() error {
+				return withTimeout(ctx, opts.timeouts.Bind, "binding services", func(ctx context.Context) error {
+					return copyServiceBindings(ctx, appRepo, appName, venerableName)
+				})
 			},
+			ReversePrevious: reverse,
+		},
+		// copy network policies from old app to new app
+		{
+			Forward:         policies.Copy,
+			ReversePrevious: reverse,
+		},
+		// copy app features and metadata from old app to new app
+		{
+			Forward: func() error {
+				return copyAppSettings(appRepo, appName, venerableName)
+			},
+			ReversePrevious: reverse,
 		},
 		// restart
 		{
 			Forward: func() error {
-				return appRepo.StartApplication(appName)
+				// waiting for a staging slot does not count against the timeout
+				if err := appRepo.throttle.AcquireStaging(ctx); err != nil {
+					return fmt.Errorf("waiting for a staging slot cancelled: %w", context.Cause(ctx))
+				}
+				defer appRepo.throttle.ReleaseStaging()
+				// starting the new app stages it
+				return withTimeout(ctx, opts.timeouts.StagingAndStart(), "staging and starting", func(ctx context.Context) error {
+					return startWithLogs(ctx, appRepo, appName)
+				})
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// check that every process of new app is running at scale
+		{
+			Forward: func() error {
+				return withTimeout(ctx, opts.timeouts.Verify, "waiting for processes", func(ctx context.Context) error {
+					return verifyProcesses(ctx, appRepo, appName, venerableName)
+				})
+			},
+			ReversePrevious: reverse,
+		},
+		// check that both apps expose the same routes
+		{
+			Forward: func() error {
+				return verifyRoutes(appRepo, appName, venerableName)
+			},
+			ReversePrevious: reverse,
+		},
+		// watch the new app before removing the old one
+		{
+			Forward: func() error {
+				return watchApp(ctx, appRepo, appName, opts.watch)
+			},
+			ReversePrevious: reverse,
+		},
+		// compare the container metrics of both apps
+		{
+			Forward: func() error {
+				return compareMetrics(ctx, appRepo, appName, venerableName, opts.metrics)
 			},
+			ReversePrevious: reverse,
 		},
 		// cleanup the old app
 		{
 			Forward: func() error {
-				switch cleanup {
+				switch opts.cleanup {
 				case deleteOnCleanup:
-					return appRepo.DeleteApplication(venerableAppName(appName))
+					if err := policies.Cleanup(); err != nil {
+						return err
+					}
+					return appRepo.DeleteApplication(venerableName)
 				case stopOnCleanup:
-					return appRepo.StopApplication(venerableAppName(appName))
-				default:
-					return nil
+					if err := appRepo.StopApplication(venerableName); err != nil {
+						return err
+					}
 				}
+				markRetained(appRepo, venerableName, opts.retainFor)
+				return nil
 			},
 		},
 	}
 }
+
+func restageInPlaceActions(ctx context.Context, appRepo *ApplicationRepo, appName string, opts options) []rewind.Action {
+	return []rewind.Action{
+		// stage a new droplet and set it as current, without starting the app
+		{
+			Forward: func() error {
+				if err := appRepo.throttle.AcquireStaging(ctx); err != nil {
+					return fmt.Errorf("waiting for a staging slot cancelled: %w", context.Cause(ctx))
+				}
+				defer appRepo.throttle.ReleaseStaging()
+				return withTimeout(ctx, opts.timeouts.Staging, "staging", func(ctx context.Context) error {
+					return restageInPlace(ctx, appRepo, appName, opts.buildpackOverride)
+				})
+			},
+		},
+	}
+}
+
+// copyBits copies the application bits of the venerable app to the new app.
+func copyBits(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copying application bits from %s to new %s\n",
+		terminal.EntityNameColor(venerableName),
+		terminal.EntityNameColor(appName),
+	)
+	pb := NewIndeterminateProgressBar(os.Stdout, "")
+
+	job, err := appRepo.CopyBits(oldAppGUID, newAppGUID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		pb.Next()
+		job, err := appRepo.GetJob(job.Entity.GUID)
+		switch {
+		case err != nil:
+			fmt.Println("FAILED")
+			return err
+		case job.Entity.Status == "finished":
+			fmt.Println("OK")
+			return nil
+		case job.Entity.Status == "failed":
+			fmt.Println("FAILED")
+			return fmt.Errorf(
+				"Error %s, %s [code: %d]",
+				job.Entity.ErrorDetails.ErrorCode,
+				job.Entity.ErrorDetails.Description,
+				job.Entity.ErrorDetails.Code,
+			)
+		}
+		if err := sleep(ctx, 500*time.Millisecond); err != nil {
+			fmt.Println("FAILED")
+			return err
+		}
+	}
+}
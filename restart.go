@@ -1,132 +1,154 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"time"
+	"context"
 
-	"code.cloudfoundry.org/cli/cf/terminal"
 	"github.com/contraband/autopilot/rewind"
 )
 
-func restartActions(appRepo *ApplicationRepo, appName string, cleanup cleanupAction) []rewind.Action {
+func restartActions(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string, docker bool, opts options, lock *appLock) []rewind.Action {
+	policies := &networkPolicyMigration{appRepo: appRepo, appName: appName, venerableName: venerableName, skip: opts.skipNetworkPolicies}
+	// reverse drops the new app and gives the venerable app its name back
+	reverse := func() error {
+		policies.Revert()
+		appRepo.DeleteApplication(appName)
+		return restoreFromVenerable(appRepo, appName, venerableName)
+	}
+
 	return []rewind.Action{
-		// get droplet of existing app
+		// get manifest of existing app
+		{
+			Forward: func() error {
+				return exportManifest(appRepo, appName)
+			},
+		},
+		// rename old app to app-venerable
+		{
+			Forward: func() error {
+				return renameToVenerable(appRepo, appName, venerableName)
+			},
+			ReversePrevious: func() error {
+				return restoreFromVenerable(appRepo, appName, venerableName)
+			},
+		},
+		// push new app with placeholder app bits, or from the Docker image
 		{
 			Forward: func() error {
-				appGUID, err := appRepo.GetAppGuid(appName)
-				if err != nil {
+				push := appRepo.PushApplication
+				if docker {
+					push = appRepo.PushDockerApplication
+				}
+				if err := push(appName); err != nil {
 					return err
 				}
-				return appRepo.DownloadDroplet(appGUID)
+				return lock.Add(appName)
 			},
+			ReversePrevious: reverse,
 		},
-		// get manifest of existing app
+		// copy app bits from old app to new app, or the Docker image
 		{
 			Forward: func() error {
-				return appRepo.CreateManifest(appName)
+				if docker {
+					return copyDockerImage(appRepo, appName, venerableName, false)
+				}
+				return withTimeout(ctx, opts.timeouts.CopyBits, "copying application bits", func(ctx context.Context) error {
+					return copyBits(ctx, appRepo, appName, venerableName)
+				})
 			},
+			ReversePrevious: reverse,
 		},
-		// rename old app to app-venerable
+		// copy the current droplet of old app to new app
 		{
 			Forward: func() error {
-				return appRepo.RenameApplication(appName, venerableAppName(appName))
-			},
-			ReversePrevious: func() error {
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+				return withTimeout(ctx, opts.timeouts.CopyBits, "copying droplet", func(ctx context.Context) error {
+					return copyDroplet(ctx, appRepo, appName, venerableName)
+				})
 			},
+			ReversePrevious: reverse,
 		},
-		// push new app with placeholder app bits
+		// map routes of old app to new app
 		{
 			Forward: func() error {
-				return appRepo.PushApplication(appName)
+				return copyRoutes(appRepo, appName, venerableName)
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// bind services of old app to new app
+		{
+			Forward: func() error {
+				return withTimeout(ctx, opts.timeouts.Bind, "binding services", func(ctx context.Context) error {
+					return copyServiceBindings(ctx, appRepo, appName, venerableName)
+				})
 			},
+			ReversePrevious: reverse,
 		},
-		// copy app bits from old app to new app
+		// copy network policies from old app to new app
+		{
+			Forward:         policies.Copy,
+			ReversePrevious: reverse,
+		},
+		// copy app features and metadata from old app to new app
 		{
 			Forward: func() error {
-				oldAppGUID, err := appRepo.GetAppGuid(venerableAppName(appName))
-				if err != nil {
-					return err
-				}
-				newAppGUID, err := appRepo.GetAppGuid(appName)
-				if err != nil {
-					return err
-				}
-
-				fmt.Printf("Copying application bits from %s to new %s\n",
-					terminal.EntityNameColor(venerableAppName(appName)),
-					terminal.EntityNameColor(appName),
-				)
-				pb := NewIndeterminateProgressBar(os.Stdout, "")
-
-				job, err := appRepo.CopyBits(oldAppGUID, newAppGUID)
-				if err != nil {
-					return err
-				}
-
-				for {
-					pb.Next()
-					job, err := appRepo.GetJob(job.Entity.GUID)
-					switch {
-					case err != nil:
-						fmt.Println("FAILED")
-						return err
-					case job.Entity.Status == "finished":
-						fmt.Println("OK")
-						return nil
-					case job.Entity.Status == "failed":
-						fmt.Println("FAILED")
-						return fmt.Errorf(
-							"Error %s, %s [code: %d]",
-							job.Entity.ErrorDetails.ErrorCode,
-							job.Entity.ErrorDetails.Description,
-							job.Entity.ErrorDetails.Code,
-						)
-					}
-					time.Sleep(500 * time.Millisecond)
-				}
+				return copyAppSettings(appRepo, appName, venerableName)
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// start the new app
+		{
+			Forward: func() error {
+				return withTimeout(ctx, opts.timeouts.Start, "starting", func(ctx context.Context) error {
+					return startWithLogs(ctx, appRepo, appName)
+				})
 			},
+			ReversePrevious: reverse,
 		},
-		// upload the droplet of the old app to the new app
+		// check that every process of new app is running at scale
 		{
 			Forward: func() error {
-				return appRepo.UploadDroplet(appName)
+				return withTimeout(ctx, opts.timeouts.Verify, "waiting for processes", func(ctx context.Context) error {
+					return verifyProcesses(ctx, appRepo, appName, venerableName)
+				})
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// check that both apps expose the same routes
+		{
+			Forward: func() error {
+				return verifyRoutes(appRepo, appName, venerableName)
 			},
+			ReversePrevious: reverse,
 		},
-		// start the new app
+		// watch the new app before removing the old one
 		{
 			Forward: func() error {
-				return appRepo.StartApplication(appName)
+				return watchApp(ctx, appRepo, appName, opts.watch)
 			},
-			ReversePrevious: func() error {
-				appRepo.DeleteApplication(appName)
-				return appRepo.RenameApplication(venerableAppName(appName), appName)
+			ReversePrevious: reverse,
+		},
+		// compare the container metrics of both apps
+		{
+			Forward: func() error {
+				return compareMetrics(ctx, appRepo, appName, venerableName, opts.metrics)
 			},
+			ReversePrevious: reverse,
 		},
 		// cleanup the old app
 		{
 			Forward: func() error {
-				switch cleanup {
+				switch opts.cleanup {
 				case deleteOnCleanup:
-					return appRepo.DeleteApplication(venerableAppName(appName))
+					if err := policies.Cleanup(); err != nil {
+						return err
+					}
+					return appRepo.DeleteApplication(venerableName)
 				case stopOnCleanup:
-					return appRepo.StopApplication(venerableAppName(appName))
-				default:
-					return nil
+					if err := appRepo.StopApplication(venerableName); err != nil {
+						return err
+					}
 				}
+				markRetained(appRepo, venerableName, opts.retainFor)
+				return nil
 			},
 		},
 	}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+// retryPolicy retries calls to the Cloud Controller failing with a
+// transient error, waiting an exponentially growing, jittered delay between
+// attempts.
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  time.Second,
+	MaxDelay:   30 * time.Second,
+}
+
+// transientErrorPattern matches the errors, as reported by the cf CLI or by
+// the Cloud Controller, that are worth retrying: gateway errors, rate
+// limiting, network failures and service instance operations in progress.
+var transientErrorPattern = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`(status|response) code: (429|502|503|504)`,
+	`bad gateway`,
+	`service unavailable`,
+	`gateway time-?out`,
+	`too many requests`,
+	`connection refused`,
+	`connection reset`,
+	`i/o timeout`,
+	`tls handshake timeout`,
+	`unexpected eof`,
+	`CF-AsyncServiceInstanceOperationInProgress`,
+	`CF-ServiceUnavailable`,
+	`CF-RateLimitExceeded`,
+}, "|"))
+
+// unprocessedErrorPattern matches the transient errors telling that the
+// Cloud Controller did not process the request at all.
+var unprocessedErrorPattern = regexp.MustCompile(`(?i)(status|response) code: 429|too many requests|CF-RateLimitExceeded|CF-AsyncServiceInstanceOperationInProgress|connection refused`)
+
+// rateLimitedPattern matches the errors reporting that the Cloud Controller
+// rate limit was exceeded.
+var rateLimitedPattern = regexp.MustCompile(`(?i)(status|response) code: 429|too many requests|CF-RateLimitExceeded`)
+
+func isRateLimited(err error, output []string) bool {
+	if err == nil {
+		return false
+	}
+	return rateLimitedPattern.MatchString(err.Error() + "\n" + strings.Join(output, "\n"))
+}
+
+// isTransient tells whether a failed call, given its error and output, is
+// worth retrying.
+func isTransient(err error, output []string) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case 429, 502, 503, 504:
+			return true
+		}
+	}
+	return transientErrorPattern.MatchString(err.Error() + "\n" + strings.Join(output, "\n"))
+}
+
+// isUnprocessed tells whether a failed call, given its error and output,
+// failed before the Cloud Controller processed it, so that it can be retried
+// even if it is not idempotent. A gateway error or a reset connection may
+// come after the request was applied.
+func isUnprocessed(err error, output []string) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == 429 {
+		return true
+	}
+	return unprocessedErrorPattern.MatchString(err.Error() + "\n" + strings.Join(output, "\n"))
+}
+
+// createsResource tells whether the request creates a resource, so that
+// repeating it after it was applied would create a duplicate or fail.
+func createsResource(method, path string) bool {
+	if method != "POST" {
+		return false
+	}
+	for _, prefix := range []string{"/v3/service_credential_bindings", "/v3/builds", "/v3/droplets", "/v3/packages"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before the given retry (starting at 1).
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay << uint(retry-1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	// "equal jitter": keep half of the delay, randomize the other half
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// do calls f until it succeeds, fails with an error that retryable does not
+// accept, the retries are exhausted or ctx is done. f returns the output of
+// the call along with its error, to help classifying it.
+func (p retryPolicy) do(ctx context.Context, description string, retryable func(err error, output []string) bool, f func() ([]string, error)) ([]string, error) {
+	for retry := 1; ; retry++ {
+		output, err := f()
+		if retry > p.MaxRetries || !retryable(err, output) {
+			return output, err
+		}
+		d := p.delay(retry)
+		fmt.Println(terminal.WarningColor(fmt.Sprintf(
+			"%s failed with a transient error, retrying in %s (retry %d of %d): %s",
+			description, d.Round(100*time.Millisecond), retry, p.MaxRetries, err,
+		)))
+		if sleep(ctx, d) != nil {
+			return output, context.Cause(ctx)
+		}
+	}
+}
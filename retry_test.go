@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		output    []string
+		transient bool
+	}{
+		{"success", nil, []string{"HTTP/1.1 502 Bad Gateway"}, false},
+		{"bad gateway status", &APIError{Status: 502, Description: "unexpected response: 502 Bad Gateway"}, nil, true},
+		{"rate limited status", &APIError{Status: 429, ErrorCode: "CF-RateLimitExceeded", Description: "Rate Limit Exceeded"}, nil, true},
+		{"gateway timeout status", &APIError{Status: 504}, nil, true},
+		{"wrapped api error", errors.Wrap(&APIError{Status: 503}, "fetching app"), nil, true},
+		{"not found", &APIError{Status: 404, ErrorCode: "CF-ResourceNotFound", Description: "App not found"}, nil, false},
+		{"unprocessable entity", &APIError{Status: 422, ErrorCode: "CF-UnprocessableEntity", Description: "name must be unique"}, nil, false},
+		{"operation in progress", &APIError{Status: 409, ErrorCode: "CF-AsyncServiceInstanceOperationInProgress", Description: "An operation for service instance db is in progress."}, nil, true},
+		{"cli server error", errors.New("Error executing cli core command"), []string{"FAILED", "Server error, status code: 502, error code: 0"}, true},
+		{"cli gateway timeout", errors.New("Error executing cli core command"), []string{"FAILED", "Gateway Timeout"}, true},
+		{"cli not found", errors.New("Error executing cli core command"), []string{"FAILED", "App myapp not found"}, false},
+		{"connection reset", errors.New("read tcp 10.0.0.1:443: read: connection reset by peer"), nil, true},
+		{"connection refused", errors.New("dial tcp 10.0.0.1:443: connect: connection refused"), nil, true},
+		{"tls handshake timeout", errors.New("net/http: TLS handshake timeout"), nil, true},
+		{"unexpected eof", errors.New("unexpected EOF"), nil, true},
+		{"decoding", errors.New("decoding response of GET /v3/apps: invalid character"), nil, false},
+	}
+	for _, test := range tests {
+		if got := isTransient(test.err, test.output); got != test.transient {
+			t.Errorf("%s: got transient %t, want %t", test.name, got, test.transient)
+		}
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		output      []string
+		rateLimited bool
+	}{
+		{"success", nil, []string{"Too Many Requests"}, false},
+		{"rate limit exceeded", &APIError{Status: 429, ErrorCode: "CF-RateLimitExceeded", Description: "Rate Limit Exceeded"}, nil, true},
+		{"cli too many requests", errors.New("Error executing cli core command"), []string{"FAILED", "Server error, status code: 429"}, true},
+		{"bad gateway", &APIError{Status: 502, Description: "unexpected response: 502 Bad Gateway"}, nil, false},
+	}
+	for _, test := range tests {
+		if got := isRateLimited(test.err, test.output); got != test.rateLimited {
+			t.Errorf("%s: got rate limited %t, want %t", test.name, got, test.rateLimited)
+		}
+	}
+}
+
+func TestIsUnprocessed(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		output      []string
+		unprocessed bool
+	}{
+		{"success", nil, nil, false},
+		{"rate limited", &APIError{Status: 429, Description: "Rate Limit Exceeded"}, nil, true},
+		{"operation in progress", &APIError{Status: 409, ErrorCode: "CF-AsyncServiceInstanceOperationInProgress"}, nil, true},
+		{"connection refused", errors.New("dial tcp 10.0.0.1:443: connect: connection refused"), nil, true},
+		{"bad gateway", &APIError{Status: 502, Description: "unexpected response: 502 Bad Gateway"}, nil, false},
+		{"gateway timeout", &APIError{Status: 504}, nil, false},
+		{"connection reset", errors.New("read tcp 10.0.0.1:443: read: connection reset by peer"), nil, false},
+		{"already bound", &APIError{Status: 422, ErrorCode: "CF-UnprocessableEntity", Description: "The app is already bound to the service instance."}, nil, false},
+	}
+	for _, test := range tests {
+		if got := isUnprocessed(test.err, test.output); got != test.unprocessed {
+			t.Errorf("%s: got unprocessed %t, want %t", test.name, got, test.unprocessed)
+		}
+	}
+}
+
+func TestCreatesResource(t *testing.T) {
+	tests := []struct {
+		method, path string
+		creates      bool
+	}{
+		{"POST", "/v3/service_credential_bindings", true},
+		{"POST", "/v3/builds", true},
+		{"POST", "/v3/droplets?source_guid=d1", true},
+		{"POST", "/v3/packages?source_guid=p1", true},
+		{"GET", "/v3/builds/b1", false},
+		{"PATCH", "/v3/apps/a1", false},
+		{"POST", "/v3/apps/a1/actions/start", false},
+		{"POST", "/v3/routes/r1/destinations", false},
+	}
+	for _, test := range tests {
+		if got := createsResource(test.method, test.path); got != test.creates {
+			t.Errorf("%s %s: got creates resource %t, want %t", test.method, test.path, got, test.creates)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := retryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	badGateway := &APIError{Status: 502}
+
+	calls := 0
+	_, err := policy.do(context.Background(), "test", isTransient, func() ([]string, error) {
+		calls++
+		if calls < 3 {
+			return nil, badGateway
+		}
+		return nil, nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("transient errors: got %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	_, err = policy.do(context.Background(), "test", isUnprocessed, func() ([]string, error) {
+		calls++
+		return nil, badGateway
+	})
+	if err != badGateway || calls != 1 {
+		t.Errorf("possibly applied error: got %v after %d calls, want the error after 1", err, calls)
+	}
+
+	calls = 0
+	_, err = policy.do(context.Background(), "test", isTransient, func() ([]string, error) {
+		calls++
+		return nil, badGateway
+	})
+	if err != badGateway || calls != 4 {
+		t.Errorf("retries exhausted: got %v after %d calls, want the error after 4", err, calls)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cause := errors.New("terminated")
+	cancel(cause)
+	policy.BaseDelay, policy.MaxDelay = time.Hour, time.Hour
+	calls = 0
+	_, err = policy.do(ctx, "test", isTransient, func() ([]string, error) {
+		calls++
+		return nil, badGateway
+	})
+	if err != cause || calls != 1 {
+		t.Errorf("cancelled: got %v after %d calls, want the cause after 1", err, calls)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"code.cloudfoundry.org/cli/plugin"
+	"github.com/contraband/autopilot/rewind"
+	"github.com/pkg/errors"
+)
+
+// findVenerable returns the name of the venerable copy of the app retained
+// in the current space, or the given one after checking it is a venerable
+// copy of the app.
+func findVenerable(appRepo *ApplicationRepo, appName, venerableName string) (string, error) {
+	scope, err := appRepo.currentScope(false)
+	if err != nil {
+		return "", err
+	}
+	apps, err := appRepo.ListApps(scope)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, app := range apps {
+		if app.Metadata.Annotations[venerableAnnotation] != appName {
+			continue
+		}
+		if venerableName == "" || app.Name == venerableName {
+			candidates = append(candidates, app.Name)
+		}
+	}
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case venerableName != "":
+		return "", fmt.Errorf("%s is not an old copy of %s", venerableName, appName)
+	case len(candidates) == 0:
+		return "", fmt.Errorf("no old copy of %s found: it is only retained by --no-delete or --no-stop", appName)
+	}
+	return "", fmt.Errorf("several old copies of %s found (%s): choose one with --venerable", appName, strings.Join(candidates, ", "))
+}
+
+// rollback swaps an app back to its venerable copy, with no downtime: the
+// venerable copy is started and mapped to the routes of the app before the
+// names are swapped and the newer copy is stopped or deleted.
+func (BgRestagePlugin) rollback(cliConnection plugin.CliConnection, args []string) error {
+	fs := flag.NewFlagSet("cf bg-rollback", flag.ExitOnError)
+	nodelete := fs.Bool("no-delete", false, "Stop but do not delete the newer copy of the application")
+	nostop := fs.Bool("no-stop", false, "Do not stop the newer copy of the application (implies --no-delete)")
+	retainFor := fs.Duration("retain-for", defaultRetention, "How long the newer copy kept by --no-delete or --no-stop is retained, before bg-cleanup deletes it")
+	venerable := fs.String("venerable", "", "Name of the old copy to roll back to, when several are retained")
+	startTimeout := fs.Duration("start-timeout", defaultTimeouts.Start, "Maximum duration of the start of the old copy of the application (0 for no limit)")
+	verifyTimeout := fs.Duration("verify-timeout", defaultTimeouts.Verify, "Maximum wait for all the instances of the old copy of the application to be running (0 for no limit)")
+	lockTTL := fs.Duration("lock-ttl", defaultLockTTL, "Duration after which the lock taken on an application is considered stale")
+	forceUnlock := fs.Bool("force-unlock", false, "Remove the lock left on an application by an operation that is no longer running")
+	skipNetworkPolicies := fs.Bool("skip-network-policies", false, "Do not delete the network policies of the newer copy of the application, e.g. without the network.write scope")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one application name")
+	}
+	if *startTimeout < 0 || *verifyTimeout < 0 || *lockTTL <= 0 || *retainFor <= 0 {
+		fs.Usage()
+		return fmt.Errorf("illegal timeout")
+	}
+	appName := fs.Arg(0)
+	cleanup := deleteOnCleanup
+	if *nostop {
+		cleanup = skipCleanup
+	} else if *nodelete {
+		cleanup = stopOnCleanup
+	}
+
+	appRepo, err := NewApplicationRepo(cliConnection)
+	if err != nil {
+		return err
+	}
+	defer appRepo.DeleteDir()
+
+	venerableName, err := findVenerable(appRepo, appName, *venerable)
+	if err != nil {
+		return err
+	}
+	// the newer copy keeps a distinct name, in case it is retained
+	namer, err := newVenerableNamer("{{.Name}}-rolled-back", true)
+	if err != nil {
+		return err
+	}
+	rolledBackName, err := namer.Name(appRepo, appName, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stopHandlingSignals := handleSignals(cancel, func() map[string]string { return nil }, func() { appRepo.DeleteDir() })
+	defer stopHandlingSignals()
+
+	lock, err := acquireLock(appRepo, appName, "bg-rollback", *lockTTL, *forceUnlock)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+	if err := lock.Add(venerableName); err != nil {
+		return err
+	}
+
+	venerableApp, err := appRepo.GetApp(venerableName)
+	if err != nil {
+		return err
+	}
+	started := false
+	scaleBack := func() error { return nil }
+	// stopVenerable stops the venerable copy again if it was started, and
+	// gives it back its scale
+	stopVenerable := func() error {
+		if started {
+			if err := appRepo.StopApplication(venerableName); err != nil {
+				return err
+			}
+		}
+		return scaleBack()
+	}
+
+	actionList := []rewind.Action{
+		// scale the old copy as the app it replaces
+		{
+			Forward: func() error {
+				back, err := matchScale(appRepo, venerableName, appName)
+				if back != nil {
+					scaleBack = back
+				}
+				return err
+			},
+			ReversePrevious: func() error {
+				return scaleBack()
+			},
+		},
+		// start the old copy
+		{
+			Forward: func() error {
+				if venerableApp.State != "stopped" {
+					return nil
+				}
+				started = true
+				return withTimeout(ctx, *startTimeout, "starting", func(ctx context.Context) error {
+					return startWithLogs(ctx, appRepo, venerableName)
+				})
+			},
+			ReversePrevious: stopVenerable,
+		},
+		// check that every process of the old copy is running at scale
+		{
+			Forward: func() error {
+				return withTimeout(ctx, *verifyTimeout, "waiting for processes", func(ctx context.Context) error {
+					return verifyProcesses(ctx, appRepo, venerableName, appName)
+				})
+			},
+			ReversePrevious: stopVenerable,
+		},
+		// map the routes of the app to the old copy
+		{
+			Forward: func() error {
+				if err := copyRoutes(appRepo, venerableName, appName); err != nil {
+					return err
+				}
+				return verifyRoutes(appRepo, venerableName, appName)
+			},
+			ReversePrevious: stopVenerable,
+		},
+		// swap the names
+		{
+			Forward: func() error {
+				// the newer copy becomes an old copy in turn, so that a
+				// retained one can be rolled back to
+				if err := renameToVenerable(appRepo, appName, rolledBackName); err != nil {
+					return err
+				}
+				return restoreFromVenerable(appRepo, appName, venerableName)
+			},
+			ReversePrevious: func() error {
+				// either rename may have failed
+				if exists, err := appRepo.DoesAppExist(venerableName); err != nil {
+					return err
+				} else if !exists {
+					if err := renameToVenerable(appRepo, appName, venerableName); err != nil {
+						return err
+					}
+				}
+				if exists, err := appRepo.DoesAppExist(rolledBackName); err != nil {
+					return err
+				} else if exists {
+					if err := restoreFromVenerable(appRepo, appName, rolledBackName); err != nil {
+						return err
+					}
+				}
+				return stopVenerable()
+			},
+		},
+		// cleanup the newer copy
+		{
+			Forward: func() error {
+				switch cleanup {
+				case deleteOnCleanup:
+					if !*skipNetworkPolicies {
+						if err := deleteNetworkPolicies(appRepo, rolledBackName); err != nil {
+							return err
+						}
+					}
+					return appRepo.DeleteApplication(rolledBackName)
+				case stopOnCleanup:
+					if err := appRepo.StopApplication(rolledBackName); err != nil {
+						return err
+					}
+				}
+				markRetained(appRepo, rolledBackName, *retainFor)
+				return nil
+			},
+		},
+	}
+	for i := range actionList {
+		forward := actionList[i].Forward
+		actionList[i].Forward = func() error {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+			appRepo.ctx = ctx
+			defer func() { appRepo.ctx = nil }()
+			return forward()
+		}
+	}
+	actions := rewind.Actions{
+		Actions:              actionList,
+		RewindFailureMessage: "bg-rollback failed: an attempt was made at rolling back changes. Please verify that everything is fine.",
+	}
+
+	fmt.Printf("Rolling back %s to %s\n", terminal.EntityNameColor(appName), terminal.EntityNameColor(venerableName))
+	err = actions.Execute()
+	recordOutcome(appRepo, appName, "bg-rollback", err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(errTerminated, err.Error())
+		}
+		return err
+	}
+
+	fmt.Print("\nbg-rollback of " + appName + " completed successfully\n\n")
+	return nil
+}
+
+// deleteNetworkPolicies removes the policies having the app as source or
+// destination.
+func deleteNetworkPolicies(appRepo *ApplicationRepo, appName string) error {
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	policies, err := appRepo.GetNetworkPolicies(appGUID)
+	if err != nil {
+		return err
+	}
+	return appRepo.DeleteNetworkPolicies(policies)
+}
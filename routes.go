@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+type Route struct {
+	GUID     string `json:"guid"`
+	Protocol string `json:"protocol"`
+	URL      string `json:"url"`
+}
+
+type RouteDestination struct {
+	App struct {
+		GUID    string `json:"guid"`
+		Process struct {
+			Type string `json:"type"`
+		} `json:"process"`
+	} `json:"app"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// routeMapping is a route destination of an app, identified independently
+// of the app it points to.
+type routeMapping struct {
+	Route       Route
+	ProcessType string
+	Port        int
+	Protocol    string
+}
+
+func (m routeMapping) String() string {
+	s := fmt.Sprintf("%s (process %s", m.Route.URL, m.ProcessType)
+	if m.Port != 0 {
+		s += fmt.Sprintf(", port %d", m.Port)
+	}
+	if m.Protocol != "" {
+		s += ", protocol " + m.Protocol
+	}
+	return s + ")"
+}
+
+func (repo *ApplicationRepo) GetAppRoutes(appGUID string) ([]Route, error) {
+	var resp struct {
+		Resources []Route `json:"resources"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/apps/%s/routes?per_page=5000", appGUID), nil)
+	return resp.Resources, err
+}
+
+func (repo *ApplicationRepo) GetRouteDestinations(routeGUID string) ([]RouteDestination, error) {
+	var resp struct {
+		Destinations []RouteDestination `json:"destinations"`
+	}
+	err := repo.curl(&resp, "GET", fmt.Sprintf("/v3/routes/%s/destinations", routeGUID), nil)
+	return resp.Destinations, err
+}
+
+func (repo *ApplicationRepo) AddRouteDestinations(routeGUID string, destinations []RouteDestination) error {
+	return repo.curl(nil, "POST", fmt.Sprintf("/v3/routes/%s/destinations", routeGUID), map[string][]RouteDestination{"destinations": destinations})
+}
+
+// GetRouteMappings returns every route destination of the app, including
+// internal and TCP routes, sorted for comparison.
+func (repo *ApplicationRepo) GetRouteMappings(appGUID string) ([]routeMapping, error) {
+	routes, err := repo.GetAppRoutes(appGUID)
+	if err != nil {
+		return nil, err
+	}
+	var mappings []routeMapping
+	for _, route := range routes {
+		destinations, err := repo.GetRouteDestinations(route.GUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, destination := range destinations {
+			if destination.App.GUID != appGUID {
+				continue
+			}
+			mappings = append(mappings, routeMapping{
+				Route:       route,
+				ProcessType: destination.App.Process.Type,
+				Port:        destination.Port,
+				Protocol:    destination.Protocol,
+			})
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].String() < mappings[j].String()
+	})
+	return mappings, nil
+}
+
+// withoutRoutes removes the routes from the manifest so that pushing the new
+// app does not map any: copyRoutes maps the exact destinations of the
+// venerable app afterwards.
+func withoutRoutes(app ManifestApp) error {
+	delete(app, "routes")
+	delete(app, "random-route")
+	app["no-route"] = true
+	return nil
+}
+
+// copyRoutes maps the new app to every route destination of the venerable
+// app, with the same process type, port and protocol.
+func copyRoutes(appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	mappings, err := appRepo.GetRouteMappings(oldAppGUID)
+	if err != nil {
+		return err
+	}
+
+	for _, mapping := range mappings {
+		fmt.Printf("Mapping route %s to new %s\n",
+			terminal.EntityNameColor(mapping.String()),
+			terminal.EntityNameColor(appName),
+		)
+		var destination RouteDestination
+		destination.App.GUID = newAppGUID
+		destination.App.Process.Type = mapping.ProcessType
+		destination.Port = mapping.Port
+		destination.Protocol = mapping.Protocol
+		if err := appRepo.AddRouteDestinations(mapping.Route.GUID, []RouteDestination{destination}); err != nil {
+			fmt.Println("FAILED")
+			return err
+		}
+		fmt.Println("OK")
+	}
+	return nil
+}
+
+// verifyRoutes checks that the new app exposes exactly the same route
+// destinations as the venerable app.
+func verifyRoutes(appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	oldMappings, err := appRepo.GetRouteMappings(oldAppGUID)
+	if err != nil {
+		return err
+	}
+	newMappings, err := appRepo.GetRouteMappings(newAppGUID)
+	if err != nil {
+		return err
+	}
+
+	missing := diffRouteMappings(oldMappings, newMappings)
+	extra := diffRouteMappings(newMappings, oldMappings)
+	if len(missing) == 0 && len(extra) == 0 {
+		return nil
+	}
+	var msg []string
+	if len(missing) > 0 {
+		msg = append(msg, "missing on new app: "+strings.Join(missing, ", "))
+	}
+	if len(extra) > 0 {
+		msg = append(msg, "only on new app: "+strings.Join(extra, ", "))
+	}
+	return fmt.Errorf("routes of %s and %s differ: %s", venerableName, appName, strings.Join(msg, "; "))
+}
+
+// diffRouteMappings returns the mappings of a that are not in b.
+func diffRouteMappings(a, b []routeMapping) []string {
+	in := make(map[string]bool, len(b))
+	for _, mapping := range b {
+		in[mapping.String()] = true
+	}
+	var diff []string
+	for _, mapping := range a {
+		if !in[mapping.String()] {
+			diff = append(diff, mapping.String())
+		}
+	}
+	return diff
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+type ServiceBinding struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	LastOperation struct {
+		State       string `json:"state"`
+		Description string `json:"description"`
+	} `json:"last_operation"`
+	Relationships struct {
+		ServiceInstance struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"service_instance"`
+	} `json:"relationships"`
+
+	// filled from the included service instances
+	ServiceInstanceName string `json:"-"`
+	ServiceInstanceType string `json:"-"`
+}
+
+// GetServiceBindings returns the app bindings of the app, optionally
+// restricted to a single service instance.
+func (repo *ApplicationRepo) GetServiceBindings(appGUID, serviceInstanceGUID string) ([]ServiceBinding, error) {
+	path := fmt.Sprintf("/v3/service_credential_bindings?type=app&app_guids=%s&include=service_instance&per_page=5000", appGUID)
+	if serviceInstanceGUID != "" {
+		path += "&service_instance_guids=" + serviceInstanceGUID
+	}
+	var resp struct {
+		Resources []ServiceBinding `json:"resources"`
+		Included  struct {
+			ServiceInstances []struct {
+				GUID string `json:"guid"`
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"service_instances"`
+		} `json:"included"`
+	}
+	if err := repo.curl(&resp, "GET", path, nil); err != nil {
+		return nil, err
+	}
+	for i, binding := range resp.Resources {
+		for _, instance := range resp.Included.ServiceInstances {
+			if instance.GUID == binding.Relationships.ServiceInstance.Data.GUID {
+				resp.Resources[i].ServiceInstanceName = instance.Name
+				resp.Resources[i].ServiceInstanceType = instance.Type
+			}
+		}
+	}
+	return resp.Resources, nil
+}
+
+func (repo *ApplicationRepo) GetServiceBindingParameters(bindingGUID string) (map[string]interface{}, error) {
+	var params map[string]interface{}
+	err := repo.curl(&params, "GET", fmt.Sprintf("/v3/service_credential_bindings/%s/parameters", bindingGUID), nil)
+	return params, err
+}
+
+// errBindingParametersNotSupported is the error code returned by the Cloud
+// Controller when the service broker cannot return binding parameters.
+const errBindingParametersNotSupported = "CF-ServiceFetchBindingParametersNotSupported"
+
+// CreateServiceBinding binds the service instance to the app and waits for
+// the binding operation to complete.
+func (repo *ApplicationRepo) CreateServiceBinding(ctx context.Context, appGUID, serviceInstanceGUID, name string, params map[string]interface{}) error {
+	body := map[string]interface{}{
+		"type": "app",
+		"relationships": map[string]interface{}{
+			"service_instance": map[string]interface{}{"data": map[string]string{"guid": serviceInstanceGUID}},
+			"app":              map[string]interface{}{"data": map[string]string{"guid": appGUID}},
+		},
+	}
+	if name != "" {
+		body["name"] = name
+	}
+	if len(params) > 0 {
+		body["parameters"] = params
+	}
+	if err := repo.curl(nil, "POST", "/v3/service_credential_bindings", body); err != nil {
+		return err
+	}
+
+	for {
+		bindings, err := repo.GetServiceBindings(appGUID, serviceInstanceGUID)
+		if err != nil {
+			return err
+		}
+		if len(bindings) == 0 {
+			return fmt.Errorf("binding to service instance %s not found after creation", serviceInstanceGUID)
+		}
+		switch bindings[0].LastOperation.State {
+		case "succeeded":
+			return nil
+		case "failed":
+			return fmt.Errorf("binding failed: %s", bindings[0].LastOperation.Description)
+		}
+		if err := sleep(ctx, 500*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+// withoutServices removes the services from the manifest so that pushing
+// the new app does not bind them: copyServiceBindings recreates the
+// bindings afterwards, with their name and parameters.
+func withoutServices(app ManifestApp) error {
+	delete(app, "services")
+	return nil
+}
+
+// copyServiceBindings binds the new app to the service instances of the
+// venerable app, carrying over binding names and binding parameters.
+func copyServiceBindings(ctx context.Context, appRepo *ApplicationRepo, appName, venerableName string) error {
+	oldAppGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	newAppGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	bindings, err := appRepo.GetServiceBindings(oldAppGUID, "")
+	if err != nil {
+		return err
+	}
+
+	for _, binding := range bindings {
+		fmt.Printf("Binding service instance %s to new %s\n",
+			terminal.EntityNameColor(binding.ServiceInstanceName),
+			terminal.EntityNameColor(appName),
+		)
+		var params map[string]interface{}
+		if binding.ServiceInstanceType == "managed" {
+			params, err = appRepo.GetServiceBindingParameters(binding.GUID)
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode == errBindingParametersNotSupported {
+				fmt.Println("FAILED")
+				return errors.Wrapf(err,
+					"cannot preserve the parameters of the binding to service instance '%s': its service broker does not support fetching binding parameters",
+					binding.ServiceInstanceName,
+				)
+			}
+			if err != nil {
+				fmt.Println("FAILED")
+				return errors.Wrapf(err, "fetching the parameters of the binding to service instance '%s'", binding.ServiceInstanceName)
+			}
+		}
+		err = appRepo.CreateServiceBinding(ctx, newAppGUID, binding.Relationships.ServiceInstance.Data.GUID, binding.Name, params)
+		if err != nil {
+			fmt.Println("FAILED")
+			return errors.Wrapf(err, "binding service instance '%s'", binding.ServiceInstanceName)
+		}
+		fmt.Println("OK")
+	}
+	return nil
+}
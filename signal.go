@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"github.com/pkg/errors"
+)
+
+const (
+	// exitInterrupted is the exit code on Ctrl-C, which stops the run
+	// without rolling back the operations in progress
+	exitInterrupted = 130
+	// exitAborted is the exit code when a terminated run was aborted by a
+	// second SIGTERM, without rolling back the operations in progress
+	exitAborted = 131
+	// exitTerminated is the exit code when the run was terminated and the
+	// operations in progress were rolled back
+	exitTerminated = 143
+)
+
+var errTerminated = errors.New("terminated")
+
+// handleSignals handles the signals stopping the run.
+//
+// On the first SIGTERM, the operations in progress are cancelled, so that
+// their current step stops and the changes of their completed steps are
+// rolled back. A second SIGTERM aborts immediately.
+//
+// Ctrl-C also reaches the cf CLI, which does not trap it and exits, closing
+// the connection a rollback would need: SIGINT exits immediately.
+//
+// Before exiting, cleanup is called and the way to recover the apps returned
+// by inProgress is printed, with the names of their venerable copies when
+// known. The returned function stops handling signals.
+func handleSignals(cancel context.CancelCauseFunc, inProgress func() map[string]string, cleanup func()) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	abort := func(message string, code int) {
+		fmt.Println(terminal.FailureColor(message))
+		printRecovery(inProgress())
+		cleanup()
+		os.Exit(code)
+	}
+
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-signals:
+		case <-done:
+			return
+		}
+		if sig == os.Interrupt {
+			abort("\nInterrupted: the operations in progress were not rolled back", exitInterrupted)
+		}
+		fmt.Println(terminal.WarningColor("\nTerminated: rolling back the operations in progress, terminate again to abort immediately"))
+		cancel(errTerminated)
+
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		abort("\nAborted: the operations in progress were not rolled back", exitAborted)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// printRecovery prints how to restore the apps, given the names of their
+// venerable copies when known.
+func printRecovery(apps map[string]string) {
+	for appName, venerableName := range apps {
+		if venerableName == "" {
+			fmt.Printf("  %s: was not renamed yet\n", terminal.EntityNameColor(appName))
+			continue
+		}
+		fmt.Printf("  %s: if %s exists, restore it with:\n", terminal.EntityNameColor(appName), terminal.EntityNameColor(venerableName))
+		fmt.Printf("      cf delete %s -f\n", appName)
+		fmt.Printf("      cf rename %s %s\n", venerableName, appName)
+		fmt.Printf("      cf start %s\n", appName)
+	}
+	fmt.Println("The next operation on these apps needs --force-unlock.")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+	"code.cloudfoundry.org/cli/plugin"
+)
+
+// status lists the apps of the current space (or org) that are locked by
+// an operation, that are leftover venerable copies, or on which an
+// operation already ran.
+func (BgRestagePlugin) status(cliConnection plugin.CliConnection, args []string) error {
+	fs := flag.NewFlagSet("cf bg-status", flag.ExitOnError)
+	org := fs.Bool("org", false, "Show the apps of the whole current org instead of the current space")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments")
+	}
+
+	appRepo, err := NewApplicationRepo(cliConnection)
+	if err != nil {
+		return err
+	}
+	defer appRepo.DeleteDir()
+
+	scope, err := appRepo.currentScope(*org)
+	if err != nil {
+		return err
+	}
+	apps, err := appRepo.ListApps(scope)
+	if err != nil {
+		return err
+	}
+
+	table := terminal.NewTable([]string{"org", "space", "name", "state", "lock", "venerable of", "last operation", "outcome"})
+	rows := 0
+	for _, app := range apps {
+		lock, venerableOf, operation, outcome := "", "", "", ""
+		if value, ok := app.Metadata.Annotations[lockAnnotation]; ok {
+			var info lockInfo
+			if json.Unmarshal([]byte(value), &info) == nil {
+				lock = info.String()
+				if info.Expired() {
+					lock += " (expired)"
+				}
+			} else {
+				lock = value
+			}
+		}
+		venerableOf = app.Metadata.Annotations[venerableAnnotation]
+		if value, ok := app.Metadata.Annotations[lastOperationAnnotation]; ok {
+			var last lastOperation
+			if json.Unmarshal([]byte(value), &last) == nil {
+				operation = fmt.Sprintf("%s at %s", last.Operation, last.FinishedAt.Local().Format(time.RFC1123))
+				outcome = last.Outcome
+				if last.Error != "" {
+					outcome += ": " + last.Error
+				}
+			}
+		}
+		if lock == "" && venerableOf == "" && operation == "" {
+			continue
+		}
+		table.Add(app.OrgName, app.SpaceName, app.Name, app.State, lock, venerableOf, operation, outcome)
+		rows++
+	}
+
+	if rows == 0 {
+		fmt.Println("No application touched by bg-restage or bg-restart")
+		return nil
+	}
+	return table.PrintTo(os.Stdout)
+}
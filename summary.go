@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// appSummary is the outcome of the operation on an app, for the run
+// summary.
+type appSummary struct {
+	Name            string
+	GUID            string
+	Outcome         eventKind
+	Message         string
+	Rollback        string
+	Started         time.Time
+	Finished        time.Time
+	BuildpackBefore string
+	BuildpackAfter  string
+	StackBefore     string
+	StackAfter      string
+	ConsoleURL      string
+}
+
+func (a appSummary) Duration() string {
+	if a.Started.IsZero() || a.Finished.IsZero() {
+		return ""
+	}
+	return a.Finished.Sub(a.Started).Round(time.Second).String()
+}
+
+// runSummary builds the summary of a run from its events.
+type runSummary struct {
+	Action   string
+	Org      string
+	Space    string
+	Started  time.Time
+	Finished time.Time
+	Apps     []*appSummary
+
+	consoleURL *texttemplate.Template
+	byName     map[string]*appSummary
+}
+
+// consoleLink holds the fields available to the --console-url template.
+type consoleLink struct {
+	GUID  string
+	Name  string
+	Space string
+	Org   string
+}
+
+func newRunSummary(action, org, space, consoleURL string) (*runSummary, error) {
+	s := &runSummary{
+		Action:  action,
+		Org:     org,
+		Space:   space,
+		Started: time.Now(),
+		byName:  map[string]*appSummary{},
+	}
+	if consoleURL != "" {
+		var err error
+		s.consoleURL, err = texttemplate.New("console-url").Option("missingkey=error").Parse(consoleURL)
+		if err != nil {
+			return nil, fmt.Errorf("illegal --console-url: %s", err)
+		}
+	}
+	return s, nil
+}
+
+// dropletBuildpacks describes the buildpacks of a droplet, with their
+// versions.
+func dropletBuildpacks(droplet *Droplet) string {
+	var buildpacks []string
+	for _, buildpack := range droplet.Buildpacks {
+		name := buildpack.BuildpackName
+		if name == "" {
+			name = buildpack.Name
+		}
+		if buildpack.Version != "" {
+			name += " " + buildpack.Version
+		}
+		buildpacks = append(buildpacks, name)
+	}
+	return strings.Join(buildpacks, ", ")
+}
+
+// Listen records an event. It is called by the event stream.
+func (s *runSummary) Listen(event runEvent) {
+	app, ok := s.byName[event.App]
+	if !ok {
+		app = &appSummary{Name: event.App}
+		s.byName[event.App] = app
+		s.Apps = append(s.Apps, app)
+	}
+	if event.AppGUID != "" {
+		app.GUID = event.AppGUID
+	}
+	switch event.Kind {
+	case eventStarted:
+		app.Started = event.Time
+		if event.Droplet != nil {
+			app.BuildpackBefore = dropletBuildpacks(event.Droplet)
+			app.StackBefore = event.Droplet.Stack
+		}
+		return
+	case eventSucceeded:
+		if event.Droplet != nil {
+			app.BuildpackAfter = dropletBuildpacks(event.Droplet)
+			app.StackAfter = event.Droplet.Stack
+		}
+	}
+	app.Outcome = event.Kind
+	app.Message = event.Message
+	app.Rollback = event.Rollback
+	app.Finished = event.Time
+	if s.consoleURL != nil && app.GUID != "" {
+		var url bytes.Buffer
+		if s.consoleURL.Execute(&url, consoleLink{GUID: app.GUID, Name: app.Name, Space: s.Space, Org: s.Org}) == nil {
+			app.ConsoleURL = url.String()
+		}
+	}
+}
+
+var markdownSummary = texttemplate.Must(texttemplate.New("markdown").Funcs(texttemplate.FuncMap{
+	// cell escapes a value for a markdown table cell
+	"cell": func(s string) string {
+		s = strings.ReplaceAll(s, "|", `\|`)
+		return strings.ReplaceAll(s, "\n", "<br>")
+	},
+}).Parse(`# {{.Action}} run in {{.Org}} / {{.Space}}
+
+Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, finished {{.Finished.Format "2006-01-02 15:04:05 MST"}}.
+
+| App | Outcome | Duration | Buildpack before | Buildpack after | Stack before | Stack after | Details |
+|-----|---------|----------|------------------|-----------------|--------------|-------------|---------|
+{{range .Apps}}| {{if .ConsoleURL}}[{{.Name}}]({{.ConsoleURL}}){{else}}{{.Name}}{{end}} | {{.Outcome}} | {{.Duration}} | {{.BuildpackBefore}} | {{.BuildpackAfter}} | {{.StackBefore}} | {{.StackAfter}} | {{.Message | cell}}{{if .Rollback}} ({{.Rollback | cell}}){{end}} |
+{{end}}`))
+
+var htmlSummary = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Action}} run in {{.Org}} / {{.Space}}</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+.succeeded { color: green; } .failed { color: red; } .pending, .skipped { color: gray; }
+td pre { margin: 0; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>{{.Action}} run in {{.Org}} / {{.Space}}</h1>
+<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, finished {{.Finished.Format "2006-01-02 15:04:05 MST"}}.</p>
+<table>
+<tr><th>App</th><th>Outcome</th><th>Duration</th><th>Buildpack before</th><th>Buildpack after</th><th>Stack before</th><th>Stack after</th><th>Details</th></tr>
+{{range .Apps}}<tr>
+<td>{{if .ConsoleURL}}<a href="{{.ConsoleURL}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+<td class="{{.Outcome}}">{{.Outcome}}</td>
+<td>{{.Duration}}</td>
+<td>{{.BuildpackBefore}}</td>
+<td>{{.BuildpackAfter}}</td>
+<td>{{.StackBefore}}</td>
+<td>{{.StackAfter}}</td>
+<td>{{if .Message}}<pre>{{.Message}}</pre>{{end}}{{if .Rollback}}<p>{{.Rollback}}</p>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Write writes the summary to the file, as HTML if its extension is .html
+// or .htm, as Markdown otherwise.
+func (s *runSummary) Write(path string) error {
+	s.Finished = time.Now()
+	var out bytes.Buffer
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		err = htmlSummary.Execute(&out, s)
+	default:
+		err = markdownSummary.Execute(&out, s)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+const (
+	minThrottleBackoff = 500 * time.Millisecond
+	maxThrottleBackoff = 30 * time.Second
+	// maxThrottleWait bounds the wait for the rate limit of the Cloud
+	// Controller to reset, which may be up to an hour ahead: calls still
+	// rate limited afterwards are retried
+	maxThrottleWait = time.Minute
+)
+
+// throttle limits the calls made to the Cloud Controller by all the
+// workers of a run: it spaces calls to honour a number of requests per
+// second, slows down when the Cloud Controller reports rate limiting, and
+// caps the number of concurrent stagings.
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration // between two calls, 0 when unlimited
+	backoff  time.Duration // added to interval while rate limited
+	next     time.Time
+	stagings chan struct{} // nil when unlimited
+}
+
+func newThrottle(requestsPerSecond float64, maxStagings int) *throttle {
+	t := &throttle{}
+	if requestsPerSecond > 0 {
+		t.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	if maxStagings > 0 {
+		t.stagings = make(chan struct{}, maxStagings)
+	}
+	return t
+}
+
+// Wait blocks until the next call may be made, or until ctx is done.
+func (t *throttle) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(t.interval + t.backoff)
+	t.mu.Unlock()
+	return sleep(ctx, time.Until(at))
+}
+
+// SlowDown doubles the delay added between calls, after the Cloud
+// Controller rejected a call for exceeding its rate limit.
+func (t *throttle) SlowDown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	backoff := t.backoff * 2
+	if backoff < minThrottleBackoff {
+		backoff = minThrottleBackoff
+	}
+	if backoff > maxThrottleBackoff {
+		backoff = maxThrottleBackoff
+	}
+	if backoff == t.backoff {
+		return
+	}
+	t.backoff = backoff
+	fmt.Println(terminal.WarningColor(fmt.Sprintf("Rate limited by the Cloud Controller, slowing down to one call every %s", t.interval+t.backoff)))
+}
+
+// Observe adapts the pace of calls to the response of a call: it slows
+// down on 429 responses or when few requests remain before the rate limit
+// is reached (waiting for the limit to reset when none remain, at most
+// maxThrottleWait), and speeds up again otherwise.
+func (t *throttle) Observe(status int, header http.Header) {
+	if status == http.StatusTooManyRequests {
+		t.SlowDown()
+		return
+	}
+	limit, errLimit := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, errRemaining := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if errLimit != nil || errRemaining != nil || limit <= 0 {
+		t.speedUp()
+		return
+	}
+	if remaining == 0 {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			resetAt := time.Unix(reset, 0)
+			if latest := time.Now().Add(maxThrottleWait); resetAt.After(latest) {
+				resetAt = latest
+			}
+			t.mu.Lock()
+			if resetAt.After(t.next) {
+				t.next = resetAt
+				fmt.Println(terminal.WarningColor(fmt.Sprintf("Rate limit of the Cloud Controller reached, waiting %s before the next call",
+					time.Until(resetAt).Round(time.Second))))
+			}
+			t.mu.Unlock()
+		}
+	}
+	if remaining < limit/10 {
+		t.SlowDown()
+		return
+	}
+	t.speedUp()
+}
+
+func (t *throttle) speedUp() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backoff /= 2
+	if t.backoff < minThrottleBackoff {
+		t.backoff = 0
+	}
+}
+
+// AcquireStaging blocks until a staging slot is available, or until ctx is
+// done.
+func (t *throttle) AcquireStaging(ctx context.Context) error {
+	if t.stagings == nil {
+		return ctx.Err()
+	}
+	select {
+	case t.stagings <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *throttle) ReleaseStaging() {
+	if t.stagings != nil {
+		<-t.stagings
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// timeouts bound the duration of the long running steps of an operation,
+// and of the whole operation. A zero timeout means no limit.
+type timeouts struct {
+	CopyBits time.Duration
+	Bind     time.Duration
+	Staging  time.Duration
+	Start    time.Duration
+	Verify   time.Duration
+	Total    time.Duration
+}
+
+var defaultTimeouts = timeouts{
+	CopyBits: 15 * time.Minute,
+	Bind:     10 * time.Minute,
+	Staging:  15 * time.Minute,
+	Start:    10 * time.Minute,
+	Verify:   5 * time.Minute,
+}
+
+// StagingAndStart bounds starting an app that needs to be staged first.
+func (t timeouts) StagingAndStart() time.Duration {
+	if t.Staging == 0 || t.Start == 0 {
+		return 0
+	}
+	return t.Staging + t.Start
+}
+
+// withTimeout runs the step f with a context cancelled after d, and turns
+// the resulting cancellation into a meaningful error.
+func withTimeout(ctx context.Context, d time.Duration, step string, f func(ctx context.Context) error) error {
+	stepCtx := ctx
+	if d > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	err := f(stepCtx)
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%s cancelled: %w", step, context.Cause(ctx))
+	case stepCtx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%s timed out after %s", step, d)
+	}
+	return err
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"code.cloudfoundry.org/cli/cf/terminal"
+)
+
+const (
+	// venerableAnnotation marks the venerable copy of an app, with the name
+	// of the app as value
+	venerableAnnotation = annotationPrefix + "venerable-of"
+	// lastOperationAnnotation records the outcome of the last operation on
+	// an app
+	lastOperationAnnotation = annotationPrefix + "last-operation"
+)
+
+const (
+	// defaultVenerableName is the default template of the name of the
+	// venerable copy of an app
+	defaultVenerableName = "{{.Name}}-venerable"
+	// maxAppNameLength is the maximum length of an app name accepted by the
+	// Cloud Controller
+	maxAppNameLength = 255
+)
+
+// venerableNamer names the venerable copies of apps after a template.
+type venerableNamer struct {
+	template *template.Template
+	// disambiguate appends a number to the name of a venerable copy when an
+	// app of that name already exists, instead of failing
+	disambiguate bool
+}
+
+// venerableNameFields are the fields of the venerable name template.
+type venerableNameFields struct {
+	Name      string
+	Timestamp string
+}
+
+// suffixTemplate returns the template of a venerable name made of the name
+// of the app and the suffix, taken literally.
+func suffixTemplate(suffix string) string {
+	return "{{.Name}}{{" + strconv.Quote(suffix) + "}}"
+}
+
+func newVenerableNamer(tmpl string, disambiguate bool) (*venerableNamer, error) {
+	t, err := template.New("venerable-name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	n := &venerableNamer{template: t, disambiguate: disambiguate}
+	// catch the templates that cannot give a valid name before any change
+	if _, err := n.render("app", time.Now()); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *venerableNamer) render(appName string, now time.Time) (string, error) {
+	var name bytes.Buffer
+	err := n.template.Execute(&name, venerableNameFields{
+		Name:      appName,
+		Timestamp: now.UTC().Format("20060102-150405"),
+	})
+	if err != nil {
+		return "", err
+	}
+	if name.String() == appName {
+		return "", fmt.Errorf("the name of the old copy of %s must differ from %s", appName, appName)
+	}
+	return name.String(), validateAppName(name.String())
+}
+
+// validateAppName checks the constraints of the Cloud Controller on app
+// names.
+func validateAppName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("app name '%s' is empty", name)
+	case len(name) > maxAppNameLength:
+		return fmt.Errorf("app name '%s' is longer than %d characters", name, maxAppNameLength)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("app name %q contains control characters", name)
+	}
+	return nil
+}
+
+// Name returns the name of the venerable copy of the app, checking that no
+// app of that name exists.
+func (n *venerableNamer) Name(appRepo *ApplicationRepo, appName string, now time.Time) (string, error) {
+	base, err := n.render(appName, now)
+	if err != nil {
+		return "", err
+	}
+	name := base
+	for i := 2; ; i++ {
+		exists, err := appRepo.DoesAppExist(name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return name, nil
+		}
+		if !n.disambiguate {
+			return "", fmt.Errorf("an app named %s already exists, maybe left by a previous run: delete or rename it, or use --disambiguate", name)
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+		if err := validateAppName(name); err != nil {
+			return "", err
+		}
+	}
+}
+
+type lastOperation struct {
+	Operation  string    `json:"operation"`
+	Outcome    string    `json:"outcome"`
+	FinishedAt time.Time `json:"finished_at"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (repo *ApplicationRepo) setAnnotation(appGUID, key string, value *string) error {
+	return repo.UpdateAppMetadata(appGUID, AppMetadata{Annotations: map[string]*string{key: value}})
+}
+
+// renameToVenerable renames the app to its venerable name, and marks it as
+// the venerable copy of the app.
+func renameToVenerable(appRepo *ApplicationRepo, appName, venerableName string) error {
+	if err := appRepo.RenameApplication(appName, venerableName); err != nil {
+		return err
+	}
+	appGUID, err := appRepo.GetAppGuid(venerableName)
+	if err != nil {
+		return err
+	}
+	return appRepo.setAnnotation(appGUID, venerableAnnotation, &appName)
+}
+
+// restoreFromVenerable gives the venerable app its name back, and removes
+// the marks of a venerable copy.
+func restoreFromVenerable(appRepo *ApplicationRepo, appName, venerableName string) error {
+	if err := appRepo.RenameApplication(venerableName, appName); err != nil {
+		return err
+	}
+	appGUID, err := appRepo.GetAppGuid(appName)
+	if err != nil {
+		return err
+	}
+	return appRepo.UpdateAppMetadata(appGUID, AppMetadata{Annotations: map[string]*string{
+		venerableAnnotation:  nil,
+		retainedAtAnnotation: nil,
+		expiresAtAnnotation:  nil,
+	}})
+}
+
+// recordOutcome records the outcome of the operation on the app bearing
+// the name of the app at the end of the operation: the new app when it
+// succeeded, the original app when it was rolled back.
+func recordOutcome(appRepo *ApplicationRepo, appName, operation string, opErr error) {
+	record := lastOperation{
+		Operation:  operation,
+		Outcome:    "succeeded",
+		FinishedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if opErr != nil {
+		record.Outcome = "failed"
+		record.Error = opErr.Error()
+		// annotation values are limited to 5000 characters
+		if len(record.Error) > 1000 {
+			record.Error = record.Error[:1000] + "..."
+		}
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		var appGUID string
+		appGUID, err = appRepo.GetAppGuid(appName)
+		if err == nil {
+			value := string(data)
+			err = appRepo.setAnnotation(appGUID, lastOperationAnnotation, &value)
+		}
+	}
+	if err != nil {
+		fmt.Println(terminal.WarningColor(fmt.Sprintf("Could not record the outcome of %s on %s: %s", operation, appName, err)))
+	}
+}
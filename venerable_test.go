@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"code.cloudfoundry.org/cli/plugin"
+	plugin_models "code.cloudfoundry.org/cli/plugin/models"
+)
+
+// appsConnection answers the lookups of apps by name in the current space.
+type appsConnection struct {
+	plugin.CliConnection
+	apps map[string]bool
+}
+
+func (c *appsConnection) GetCurrentSpace() (plugin_models.Space, error) {
+	return plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Guid: "space-guid", Name: "space"}}, nil
+}
+
+func (c *appsConnection) CliCommandWithoutTerminalOutput(args ...string) ([]string, error) {
+	path, err := url.Parse(args[len(args)-1])
+	if err != nil || path.Path != "/v3/apps" {
+		return nil, fmt.Errorf("unexpected command %v", args)
+	}
+	body := `{"resources": []}`
+	if name := path.Query().Get("names"); c.apps[name] {
+		body = fmt.Sprintf(`{"resources": [{"name": %q}]}`, name)
+	}
+	return []string{"HTTP/1.1 200 OK", "Content-Type: application/json", "", body}, nil
+}
+
+var operationStart = time.Date(2026, 10, 19, 22, 15, 0, 0, time.UTC)
+
+func TestVenerableNamerRender(t *testing.T) {
+	tests := []struct {
+		template, appName string
+		want              string
+		valid             bool
+	}{
+		{defaultVenerableName, "myapp", "myapp-venerable", true},
+		{"{{.Name}}-old-{{.Timestamp}}", "myapp", "myapp-old-20261019-221500", true},
+		{"old-{{.Name}}", "myapp", "old-myapp", true},
+		{"{{.Name}}", "myapp", "", false},
+		{"{{if eq .Name \"app\"}}other{{else}}{{.Name}}{{end}}", "myapp", "", false},
+		{"{{.Name}}-venerable", strings.Repeat("a", maxAppNameLength-len("-venerable")), strings.Repeat("a", maxAppNameLength-len("-venerable")) + "-venerable", true},
+		{"{{.Name}}-venerable", strings.Repeat("a", maxAppNameLength-len("-venerable")+1), "", false},
+		{"{{.Name}}\n", "myapp", "", false},
+		{"{{.Missing}}", "myapp", "", false},
+		{suffixTemplate("-{{.Timestamp}}"), "myapp", "myapp-{{.Timestamp}}", true},
+		{suffixTemplate(`-"old"}}`), "myapp", `myapp-"old"}}`, true},
+	}
+	for _, test := range tests {
+		namer := &venerableNamer{template: mustParseTemplate(t, test.template)}
+		got, err := namer.render(test.appName, operationStart)
+		if (err == nil) != test.valid || (test.valid && got != test.want) {
+			t.Errorf("render(%q, %q): got %q, %v, want %q", test.template, test.appName, got, err, test.want)
+		}
+	}
+}
+
+func mustParseTemplate(t *testing.T, tmpl string) *template.Template {
+	t.Helper()
+	parsed, err := template.New("venerable-name").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		t.Fatalf("parsing %q: %s", tmpl, err)
+	}
+	return parsed
+}
+
+func TestNewVenerableNamer(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{defaultVenerableName, true},
+		{suffixTemplate("-{{"), true},
+		{"{{.Name}}-{{", false},
+		{"{{.Name}}", false},
+		{"{{.Name}}" + strings.Repeat("-", maxAppNameLength), false},
+	}
+	for _, test := range tests {
+		if _, err := newVenerableNamer(test.template, false); (err == nil) != test.valid {
+			t.Errorf("newVenerableNamer(%q): got error %v", test.template, err)
+		}
+	}
+}
+
+func TestValidateAppName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"myapp-venerable", true},
+		{"my app {{ é }}", true},
+		{"", false},
+		{"  ", false},
+		{strings.Repeat("a", maxAppNameLength), true},
+		{strings.Repeat("a", maxAppNameLength+1), false},
+		{"myapp\tvenerable", false},
+	}
+	for _, test := range tests {
+		if err := validateAppName(test.name); (err == nil) != test.valid {
+			t.Errorf("validateAppName(%q): got error %v", test.name, err)
+		}
+	}
+}
+
+func TestVenerableNamerName(t *testing.T) {
+	longName := strings.Repeat("a", maxAppNameLength-len("-venerable"))
+	tests := []struct {
+		name         string
+		appName      string
+		existing     []string
+		disambiguate bool
+		want         string
+	}{
+		{"free name", "myapp", nil, false, "myapp-venerable"},
+		{"taken name", "myapp", []string{"myapp-venerable"}, false, ""},
+		{"second copy", "myapp", []string{"myapp-venerable"}, true, "myapp-venerable-2"},
+		{"third copy", "myapp", []string{"myapp-venerable", "myapp-venerable-2"}, true, "myapp-venerable-3"},
+		{"gap in the copies", "myapp", []string{"myapp-venerable", "myapp-venerable-3"}, true, "myapp-venerable-2"},
+		{"disambiguated name too long", longName, []string{longName + "-venerable"}, true, ""},
+	}
+	for _, test := range tests {
+		conn := &appsConnection{apps: map[string]bool{}}
+		for _, name := range test.existing {
+			conn.apps[name] = true
+		}
+		appRepo := &ApplicationRepo{conn: conn, retry: defaultRetryPolicy, throttle: newThrottle(0, 0)}
+		namer, err := newVenerableNamer(defaultVenerableName, test.disambiguate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := namer.Name(appRepo, test.appName, operationStart)
+		if (err == nil) != (test.want != "") || got != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceWindow is a daily time range during which apps may be
+// restaged or restarted. A window ending before it starts spans midnight;
+// it then belongs to the weekday it starts on.
+type maintenanceWindow struct {
+	start    time.Duration // since midnight
+	end      time.Duration // since midnight
+	location *time.Location
+	days     map[time.Weekday]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseMaintenanceWindow parses a window such as "22:00-05:00" in the
+// given timezone, restricted to a comma separated list of weekdays such as
+// "mon,tue,wed,thu" (every day when empty).
+func parseMaintenanceWindow(spec, timezone, days string) (*maintenanceWindow, error) {
+	bounds := strings.Split(spec, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid window '%s': expected HH:MM-HH:MM", spec)
+	}
+	w := &maintenanceWindow{days: map[time.Weekday]bool{}}
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window '%s': expected HH:MM-HH:MM", spec)
+		}
+		d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		if i == 0 {
+			w.start = d
+		} else {
+			w.end = d
+		}
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid window '%s': empty time range", spec)
+	}
+
+	var err error
+	w.location, err = time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window timezone '%s'", timezone)
+	}
+
+	if days == "" {
+		for _, day := range weekdays {
+			w.days[day] = true
+		}
+		return w, nil
+	}
+	for _, name := range strings.Split(days, ",") {
+		// accept both "mon" and "monday"
+		key := strings.ToLower(strings.TrimSpace(name))
+		if len(key) > 3 {
+			key = key[:3]
+		}
+		day, ok := weekdays[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid window weekday '%s'", name)
+		}
+		w.days[day] = true
+	}
+	return w, nil
+}
+
+// Contains tells whether t is within the window. The time of day is read on
+// the wall clock, so that the window keeps its bounds on the days daylight
+// saving time starts or ends.
+func (w *maintenanceWindow) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	if w.start < w.end {
+		return w.days[t.Weekday()] && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	// the window spans midnight
+	if sinceMidnight >= w.start {
+		return w.days[t.Weekday()]
+	}
+	return sinceMidnight < w.end && w.days[(t.Weekday()+6)%7]
+}
+
+// NextOpening returns when the window opens next after t.
+func (w *maintenanceWindow) NextOpening(t time.Time) time.Time {
+	t = t.In(w.location)
+	for i := 0; i <= 7; i++ {
+		opening := time.Date(t.Year(), t.Month(), t.Day()+i, int(w.start.Hours()), int(w.start.Minutes())%60, 0, 0, w.location)
+		if opening.After(t) && w.days[opening.Weekday()] {
+			return opening
+		}
+	}
+	return t
+}
+
+func (w *maintenanceWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%s-%s %s", format(w.start), format(w.end), w.location)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustParseWindow(t *testing.T, spec, days string) *maintenanceWindow {
+	t.Helper()
+	w, err := parseMaintenanceWindow(spec, "Europe/Paris", days)
+	if err != nil {
+		t.Fatalf("parseMaintenanceWindow(%q, %q): %s", spec, days, err)
+	}
+	return w
+}
+
+func paris(t *testing.T, value string) time.Time {
+	t.Helper()
+	location, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		spec, timezone, days string
+		valid                bool
+	}{
+		{"22:00-05:00", "UTC", "", true},
+		{"09:00 - 17:30", "Europe/Paris", "Mon,tuesday", true},
+		{"22:00", "UTC", "", false},
+		{"22:00-25:00", "UTC", "", false},
+		{"22:00-22:00", "UTC", "", false},
+		{"22:00-05:00", "Mars/Olympus", "", false},
+		{"22:00-05:00", "UTC", "mon,someday", false},
+	}
+	for _, test := range tests {
+		_, err := parseMaintenanceWindow(test.spec, test.timezone, test.days)
+		if (err == nil) != test.valid {
+			t.Errorf("parseMaintenanceWindow(%q, %q, %q): got error %v", test.spec, test.timezone, test.days, err)
+		}
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	tests := []struct {
+		spec, days string
+		at         string
+		contains   bool
+	}{
+		{"09:00-17:00", "", "2026-10-19 08:59", false},
+		{"09:00-17:00", "", "2026-10-19 09:00", true},
+		{"09:00-17:00", "", "2026-10-19 17:00", false},
+		{"09:00-17:00", "sat,sun", "2026-10-19 12:00", false},
+		// spanning midnight, the window belongs to the day it starts on:
+		// 2026-10-19 is a Monday
+		{"22:00-05:00", "mon", "2026-10-19 23:00", true},
+		{"22:00-05:00", "mon", "2026-10-20 02:00", true},
+		{"22:00-05:00", "mon", "2026-10-20 05:00", false},
+		{"22:00-05:00", "mon", "2026-10-19 02:00", false},
+		{"22:00-05:00", "mon", "2026-10-20 23:00", false},
+		{"22:00-05:00", "sun", "2026-10-19 02:00", true},
+		// daylight saving time starts on 2026-03-29 and ends on 2026-10-25
+		{"04:00-06:00", "", "2026-03-29 04:30", true},
+		{"04:00-06:00", "", "2026-03-29 06:30", false},
+		{"04:00-06:00", "", "2026-10-25 03:30", false},
+		{"04:00-06:00", "", "2026-10-25 05:30", true},
+		{"23:00-01:00", "sat", "2026-10-25 00:30", true},
+	}
+	for _, test := range tests {
+		w := mustParseWindow(t, test.spec, test.days)
+		if got := w.Contains(paris(t, test.at)); got != test.contains {
+			t.Errorf("window %s (%s) contains %s: got %t, want %t", test.spec, test.days, test.at, got, test.contains)
+		}
+	}
+}
+
+func TestMaintenanceWindowNextOpening(t *testing.T) {
+	tests := []struct {
+		spec, days string
+		from, want string
+	}{
+		{"22:00-05:00", "", "2026-10-19 12:00", "2026-10-19 22:00"},
+		{"22:00-05:00", "", "2026-10-19 23:00", "2026-10-20 22:00"},
+		{"22:00-05:00", "mon", "2026-10-18 12:00", "2026-10-19 22:00"},
+		{"22:00-05:00", "mon", "2026-10-19 22:00", "2026-10-26 22:00"},
+		{"04:00-06:00", "", "2026-03-28 12:00", "2026-03-29 04:00"},
+		{"04:00-06:00", "", "2026-10-24 12:00", "2026-10-25 04:00"},
+	}
+	for _, test := range tests {
+		w := mustParseWindow(t, test.spec, test.days)
+		got := w.NextOpening(paris(t, test.from))
+		if want := paris(t, test.want); !got.Equal(want) {
+			t.Errorf("window %s (%s) opening next after %s: got %s, want %s", test.spec, test.days, test.from, got, want)
+		}
+	}
+}